@@ -0,0 +1,114 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// recordTypeAggregate marks DirAggregate records in storage.
+const recordTypeAggregate = "aggregate"
+
+// DirAggregate is a periodic snapshot of one directory: how many files it
+// directly contains and their combined size. It is stored alongside
+// FileData records and told apart by its type field.
+type DirAggregate struct {
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	Path      string    `json:"path"`
+	WatchRoot string    `json:"watch_root"`
+	FileCount int       `json:"file_count"`
+	TotalSize fileSize  `json:"total_size"`
+	Host      string    `json:"host,omitempty"`
+}
+
+// aggregator records a DirAggregate for every watched directory at each
+// tick of aggregate_interval.
+type aggregator struct {
+	roots     []string
+	recursive bool
+	maxDepth  int
+	host      string
+	rec       *recorder
+
+	done    chan struct{}
+	stopped sync.WaitGroup
+}
+
+func startAggregator(roots []string, config Config, rec *recorder) *aggregator {
+	a := &aggregator{
+		roots:     roots,
+		recursive: config.Recursive,
+		maxDepth:  config.MaxDepth,
+		host:      config.InstanceID,
+		rec:       rec,
+		done:      make(chan struct{}),
+	}
+	a.stopped.Add(1)
+	go a.run(config.AggregateInterval)
+	return a
+}
+
+func (a *aggregator) run(interval time.Duration) {
+	defer a.stopped.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			for _, root := range a.roots {
+				for _, agg := range a.snapshot(root, now) {
+					a.rec.add(agg)
+				}
+			}
+		case <-a.done:
+			return
+		}
+	}
+}
+
+// snapshot walks root and totals the files directly inside each directory.
+// Subdirectories are included when watching recursively, down to max_depth.
+func (a *aggregator) snapshot(root string, now time.Time) []DirAggregate {
+	byDir := make(map[string]*DirAggregate)
+	var order []string
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Printf("Failed to walk %s: %v", path, err)
+			return nil
+		}
+		if info.IsDir() {
+			if path != root && (!a.recursive || !withinDepth(root, path, a.maxDepth) || nestedRoot(a.roots, root, path)) {
+				return filepath.SkipDir
+			}
+			byDir[path] = &DirAggregate{
+				Type:      recordTypeAggregate,
+				Time:      now,
+				Path:      path,
+				WatchRoot: root,
+				Host:      a.host,
+			}
+			order = append(order, path)
+			return nil
+		}
+		if agg, ok := byDir[filepath.Dir(path)]; ok {
+			agg.FileCount++
+			agg.TotalSize += fileSize(info.Size())
+		}
+		return nil
+	})
+
+	aggregates := make([]DirAggregate, 0, len(order))
+	for _, dir := range order {
+		aggregates = append(aggregates, *byDir[dir])
+	}
+	return aggregates
+}
+
+// stop ends the ticker and waits for an in-progress snapshot to finish.
+func (a *aggregator) stop() {
+	close(a.done)
+	a.stopped.Wait()
+}
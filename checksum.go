@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// prefixSampleLen is how many bytes at the start of a file and before its
+// previous end are compared to decide that it was only appended to.
+const prefixSampleLen = 4096
+
+// hashState is what incremental checksumming remembers about a file after
+// hashing it.
+type hashState struct {
+	size    int64
+	inode   uint64
+	modTime time.Time
+	state   []byte // marshaled sha256 state after size bytes
+	head    []byte // the first prefixSampleLen bytes
+	sample  []byte // the last prefixSampleLen bytes hashed
+}
+
+// checksummer computes SHA-256 checksums. With incremental set, a file that
+// grew since its last checksum, has a modification time no earlier than
+// then, still has the same inode and still starts and ends its old content
+// with the same bytes is treated as append-only: only the new tail is
+// read, continuing from the saved hash state. Anything else, such as
+// truncation, rotation or an edit at either end, falls back to a full
+// rehash. An in-place edit between the two samples of a file that also
+// grew is not detected, so incremental checksums suit append-only files
+// only.
+type checksummer struct {
+	incremental bool
+
+	mu     sync.Mutex
+	states map[string]hashState
+}
+
+func newChecksummer(incremental bool) *checksummer {
+	return &checksummer{incremental: incremental, states: make(map[string]hashState)}
+}
+
+// checksum returns the checksum of the whole file and, when only an
+// appended tail was read, the checksum of just that tail. When also is not
+// nil it is fed the whole content of the file from the same open handle,
+// so another digest such as a fuzzy hash needs no read of its own. Only
+// when just the tail was hashed is the start of the file read again for
+// it.
+func (c *checksummer) checksum(path string, info os.FileInfo, also io.Writer) (sum, tail string, err error) {
+	f, err := openWatched(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	if !c.incremental {
+		h := sha256.New()
+		if _, err := copyHash(teeTo(h, also), f); err != nil {
+			return "", "", err
+		}
+		return hex.EncodeToString(h.Sum(nil)), "", nil
+	}
+
+	inode, _ := fileIdentity(info)
+	c.mu.Lock()
+	prev, ok := c.states[pathKey(path)]
+	c.mu.Unlock()
+
+	if ok && info.Size() > prev.size && inode == prev.inode && !info.ModTime().Before(prev.modTime) {
+		if sum, tail, next, ok := hashTail(f, prev); ok {
+			next.modTime = info.ModTime()
+			c.save(path, next)
+			if also != nil {
+				if _, err := f.Seek(0, io.SeekStart); err != nil {
+					return "", "", err
+				}
+				if _, err := copyHash(also, io.LimitReader(f, next.size)); err != nil {
+					return "", "", err
+				}
+			}
+			return sum, tail, nil
+		}
+	}
+
+	next, sum, err := hashAll(f, inode, also)
+	if err != nil {
+		return "", "", err
+	}
+	next.modTime = info.ModTime()
+	c.save(path, next)
+	return sum, "", nil
+}
+
+func (c *checksummer) save(path string, state hashState) {
+	c.mu.Lock()
+	c.states[pathKey(path)] = state
+	c.mu.Unlock()
+}
+
+// forget drops the saved state of path, which has been removed or renamed.
+func (c *checksummer) forget(path string) {
+	c.mu.Lock()
+	delete(c.states, pathKey(path))
+	c.mu.Unlock()
+}
+
+// hashAll hashes f from the start, keeping the state needed to resume.
+func hashAll(f *os.File, inode uint64, also io.Writer) (hashState, string, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return hashState{}, "", err
+	}
+	h := sha256.New()
+	head := &headBuffer{limit: prefixSampleLen}
+	sample := &tailBuffer{limit: prefixSampleLen}
+	n, err := copyHash(teeTo(io.MultiWriter(h, head, sample), also), f)
+	if err != nil {
+		return hashState{}, "", err
+	}
+	state, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return hashState{}, "", err
+	}
+	next := hashState{size: n, inode: inode, state: state, head: head.buf, sample: sample.bytes()}
+	return next, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashTail resumes prev's hash over the bytes appended since. It reports
+// false when the head or the end of the old content no longer matches or
+// anything fails, in which case the caller rehashes the whole file.
+func hashTail(f *os.File, prev hashState) (sum, tail string, next hashState, ok bool) {
+	head := make([]byte, len(prev.head))
+	if _, err := f.ReadAt(head, 0); err != nil || !bytes.Equal(head, prev.head) {
+		return "", "", hashState{}, false
+	}
+	sample := make([]byte, len(prev.sample))
+	if _, err := f.ReadAt(sample, prev.size-int64(len(sample))); err != nil || !bytes.Equal(sample, prev.sample) {
+		return "", "", hashState{}, false
+	}
+
+	h := sha256.New()
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(prev.state); err != nil {
+		return "", "", hashState{}, false
+	}
+	if _, err := f.Seek(prev.size, io.SeekStart); err != nil {
+		return "", "", hashState{}, false
+	}
+	tailHash := sha256.New()
+	newSample := &tailBuffer{limit: prefixSampleLen}
+	newSample.Write(prev.sample)
+	n, err := copyHash(io.MultiWriter(h, tailHash, newSample), f)
+	if err != nil {
+		return "", "", hashState{}, false
+	}
+	state, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		return "", "", hashState{}, false
+	}
+	next = hashState{size: prev.size + n, inode: prev.inode, state: state, head: prev.head, sample: newSample.bytes()}
+	return hex.EncodeToString(h.Sum(nil)), hex.EncodeToString(tailHash.Sum(nil)), next, true
+}
+
+// teeTo returns w, combined with also when also is not nil.
+func teeTo(w, also io.Writer) io.Writer {
+	if also == nil {
+		return w
+	}
+	return io.MultiWriter(w, also)
+}
+
+// headBuffer keeps the first limit bytes written to it.
+type headBuffer struct {
+	limit int
+	buf   []byte
+}
+
+func (h *headBuffer) Write(p []byte) (int, error) {
+	if room := h.limit - len(h.buf); room > 0 {
+		if len(p) < room {
+			room = len(p)
+		}
+		h.buf = append(h.buf, p[:room]...)
+	}
+	return len(p), nil
+}
+
+// tailBuffer keeps the last limit bytes written to it.
+type tailBuffer struct {
+	limit int
+	buf   []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.limit {
+		t.buf = append([]byte(nil), t.buf[len(t.buf)-t.limit:]...)
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) bytes() []byte {
+	return t.buf
+}
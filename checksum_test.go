@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func checksumOf(t *testing.T, c *checksummer, path string) (sum, tail string) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, tail, err = c.checksum(path, info, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := hashFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum != want {
+		t.Fatalf("checksum = %s, want the full hash %s", sum, want)
+	}
+	return sum, tail
+}
+
+func appendTo(t *testing.T, path string, data []byte) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIncrementalChecksum(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1024)
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := newChecksummer(true)
+	checksumOf(t, c, path)
+
+	appendTo(t, path, []byte("appended\n"))
+	if _, tail := checksumOf(t, c, path); tail == "" {
+		t.Error("an append was rehashed in full")
+	}
+
+	// Edit the head in place, then grow the file: the saved state no
+	// longer applies
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte("EDITED"), 0)
+	f.Close()
+	appendTo(t, path, []byte("more\n"))
+	if _, tail := checksumOf(t, c, path); tail != "" {
+		t.Error("a file edited at the start was treated as appended to")
+	}
+}
+
+func TestChecksummerForget(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := ioutil.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := newChecksummer(true)
+	checksumOf(t, c, path)
+	c.forget(path)
+	if len(c.states) != 0 {
+		t.Errorf("%d states left after forget", len(c.states))
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"time"
+)
+
+// defaultCloudEventsType prefixes the CloudEvents type of every record;
+// the past tense of the record's event is appended, e.g.
+// com.example.file.created.
+const defaultCloudEventsType = "com.example.file"
+
+// cloudEventsVerbs maps event names to the past tense used in CloudEvents
+// types. Events missing here are used as they are.
+var cloudEventsVerbs = map[string]string{
+	eventCreate:   "created",
+	eventWrite:    "modified",
+	eventChmod:    "permissions_changed",
+	eventRemove:   "removed",
+	eventMove:     "renamed",
+	eventScan:     "scanned",
+	eventComplete: "completed",
+}
+
+// cloudEventsType returns the CloudEvents type of a record of event.
+func cloudEventsType(typePrefix, event string) string {
+	if verb, ok := cloudEventsVerbs[event]; ok {
+		event = verb
+	}
+	return typePrefix + "." + event
+}
+
+// cloudEvent is a CloudEvents 1.0 envelope in the structured JSON mode.
+type cloudEvent struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Time            time.Time `json:"time"`
+	Subject         string    `json:"subject,omitempty"`
+	DataContentType string    `json:"datacontenttype"`
+	Data            FileData  `json:"data"`
+}
+
+// cloudEventsEncoder returns an encode function wrapping each record in a
+// CloudEvents envelope whose type starts with typePrefix.
+func cloudEventsEncoder(typePrefix string) func(FileData) ([]byte, error) {
+	if typePrefix == "" {
+		typePrefix = defaultCloudEventsType
+	}
+	return func(fileData FileData) ([]byte, error) {
+		ev, err := newCloudEvent(typePrefix, fileData)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(ev)
+	}
+}
+
+// newCloudEvent builds the envelope for fileData and checks that the
+// required attributes are set. The source identifies the watch root on
+// this host, as file://<host>/<watch root>.
+func newCloudEvent(typePrefix string, fileData FileData) (cloudEvent, error) {
+	id, err := newUUID()
+	if err != nil {
+		return cloudEvent{}, err
+	}
+	source := (&url.URL{Scheme: "file", Host: fileData.Host, Path: filepath.ToSlash(fileData.WatchRoot)}).String()
+	ev := cloudEvent{
+		SpecVersion:     "1.0",
+		ID:              id,
+		Source:          source,
+		Type:            cloudEventsType(typePrefix, fileData.Event),
+		Time:            fileData.Time,
+		Subject:         fileData.Path,
+		DataContentType: "application/json",
+		Data:            fileData,
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	switch {
+	case fileData.Event == "":
+		return cloudEvent{}, fmt.Errorf("cloudevents: record for %s has no event for the type attribute", fileData.Path)
+	case fileData.Host == "" && fileData.WatchRoot == "":
+		return cloudEvent{}, fmt.Errorf("cloudevents: record for %s has neither host nor watch_root for the source attribute", fileData.Path)
+	}
+	return ev, nil
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
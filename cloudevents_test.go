@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestCloudEventsType(t *testing.T) {
+	tests := []struct {
+		event string
+		want  string
+	}{
+		{eventCreate, "com.example.file.created"},
+		{eventWrite, "com.example.file.modified"},
+		{eventRemove, "com.example.file.removed"},
+		{eventMove, "com.example.file.renamed"},
+		{eventSpecial, "com.example.file.special"},
+	}
+	for _, tt := range tests {
+		ev, err := newCloudEvent(defaultCloudEventsType, FileData{Path: "/w/a.txt", WatchRoot: "/w", Host: "h", Event: tt.event})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.Type != tt.want {
+			t.Errorf("type for %s = %s, want %s", tt.event, ev.Type, tt.want)
+		}
+	}
+}
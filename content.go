@@ -0,0 +1,80 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"net/http"
+)
+
+// sniffLen is how many bytes content detection looks at, matching
+// http.DetectContentType.
+const sniffLen = 512
+
+// detectContentType returns the MIME type of the file at path based on its
+// first bytes.
+func detectContentType(path string) (string, error) {
+	f, err := openWatched(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return sniff(f)
+}
+
+// sniff detects the MIME type of the first sniffLen bytes of r.
+func sniff(r io.Reader) (string, error) {
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// detectInnerContentType decompresses just enough of a gzip file, or of the
+// first file in a zip archive, to detect the type of the content inside.
+// It returns "" when contentType is not a compressed format or the archive
+// cannot be read, so corrupt archives fall back to the outer type.
+func detectInnerContentType(path, contentType string) string {
+	switch contentType {
+	case "application/x-gzip":
+		f, err := openWatched(path)
+		if err != nil {
+			return ""
+		}
+		defer f.Close()
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return ""
+		}
+		defer zr.Close()
+		inner, err := sniff(zr)
+		if err != nil {
+			return ""
+		}
+		return inner
+	case "application/zip":
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			return ""
+		}
+		defer zr.Close()
+		for _, entry := range zr.File {
+			if entry.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := entry.Open()
+			if err != nil {
+				return ""
+			}
+			inner, err := sniff(rc)
+			rc.Close()
+			if err != nil {
+				return ""
+			}
+			return inner
+		}
+	}
+	return ""
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// pauser holds events back while watching is paused. Paused events are
+// dropped, or with buffering kept (the latest one per path) and released on
+// resume. Events are stopped before processing by admit, and records that
+// reach storage without a queued event, such as the removals reported by
+// track_moves, or that were already being processed, are stopped by
+// admitRecord.
+type pauser struct {
+	buffer bool
+
+	mu          sync.Mutex
+	paused      bool
+	held        map[string]fileEvent
+	order       []string
+	records     map[string]FileData
+	recordOrder []string
+	dropped     uint64
+}
+
+func newPauser(buffer bool) *pauser {
+	return &pauser{buffer: buffer, held: make(map[string]fileEvent), records: make(map[string]FileData)}
+}
+
+// admit reports whether ev may go on to be recorded now. While paused it
+// is held or dropped instead.
+func (p *pauser) admit(ev fileEvent) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.paused {
+		return true
+	}
+	if !p.buffer {
+		p.dropped++
+		return false
+	}
+	key := pathKey(ev.Path)
+	if _, ok := p.held[key]; !ok {
+		p.order = append(p.order, key)
+	}
+	p.held[key] = ev
+	return false
+}
+
+// admitRecord reports whether fileData may be recorded now. While paused
+// it is held or dropped instead.
+func (p *pauser) admitRecord(fileData FileData) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.paused {
+		return true
+	}
+	if !p.buffer {
+		p.dropped++
+		return false
+	}
+	key := pathKey(fileData.Path)
+	if _, ok := p.records[key]; !ok {
+		p.recordOrder = append(p.recordOrder, key)
+	}
+	p.records[key] = fileData
+	return false
+}
+
+func (p *pauser) pause() {
+	p.mu.Lock()
+	p.paused = true
+	p.mu.Unlock()
+}
+
+// resume ends the pause and returns the held events and records, each in
+// arrival order.
+func (p *pauser) resume() ([]fileEvent, []FileData) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = false
+	held := make([]fileEvent, 0, len(p.order))
+	for _, key := range p.order {
+		held = append(held, p.held[key])
+	}
+	records := make([]FileData, 0, len(p.recordOrder))
+	for _, key := range p.recordOrder {
+		records = append(records, p.records[key])
+	}
+	p.held = make(map[string]fileEvent)
+	p.order = nil
+	p.records = make(map[string]FileData)
+	p.recordOrder = nil
+	return held, records
+}
+
+// state returns whether watching is paused, how many events are held and
+// how many have been dropped so far.
+func (p *pauser) state() (paused bool, held int, dropped uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused, len(p.held) + len(p.records), p.dropped
+}
+
+// controlServer accepts line-based commands on a Unix domain socket:
+// pause, resume, status and flush. Each command gets a one-line reply.
+type controlServer struct {
+	path     string
+	listener net.Listener
+	pause    *pauser
+	rec      *recorder
+	queue    chan fileEvent
+	release  func(fileEvent)
+	rerecord func(FileData)
+
+	mu     sync.Mutex
+	conns  map[net.Conn]bool
+	closed bool
+	active sync.WaitGroup
+}
+
+// controlSocketMode limits the control socket to its owner: anyone who can
+// connect can pause recording.
+const controlSocketMode = 0600
+
+// startControlServer listens on path, replacing a stale socket left by an
+// earlier run. Anything else already at path is left alone and fails the
+// listen. Resumed events are passed to release and resumed records to
+// rerecord.
+func startControlServer(path string, pause *pauser, rec *recorder, queue chan fileEvent, release func(fileEvent), rerecord func(FileData)) (*controlServer, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, controlSocketMode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	s := &controlServer{
+		path:     path,
+		listener: listener,
+		pause:    pause,
+		rec:      rec,
+		queue:    queue,
+		release:  release,
+		rerecord: rerecord,
+		conns:    make(map[net.Conn]bool),
+	}
+	s.active.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+func (s *controlServer) serve() {
+	defer s.active.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if !closed {
+				log.Printf("Control socket error: %v", err)
+			}
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = true
+		s.active.Add(1)
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+// handle answers commands on conn until the client disconnects.
+func (s *controlServer) handle(conn net.Conn) {
+	defer s.active.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		command := strings.TrimSpace(scanner.Text())
+		if command == "" {
+			continue
+		}
+		fmt.Fprintln(conn, s.run(command))
+	}
+}
+
+// run executes one command and returns its reply.
+func (s *controlServer) run(command string) string {
+	switch strings.ToLower(command) {
+	case "pause":
+		s.pause.pause()
+		log.Printf("Paused via control socket")
+		return "ok paused"
+	case "resume":
+		held, records := s.pause.resume()
+		for _, ev := range held {
+			s.release(ev)
+		}
+		for _, fileData := range records {
+			s.rerecord(fileData)
+		}
+		released := len(held) + len(records)
+		log.Printf("Resumed via control socket, releasing %d held events", released)
+		return fmt.Sprintf("ok resumed, %d held events released", released)
+	case "status":
+		paused, held, dropped := s.pause.state()
+		state := "running"
+		if paused {
+			state = "paused"
+		}
+		if reason := s.rec.holdReason(); reason != "" {
+			state += ", " + reason
+		}
+		return fmt.Sprintf("ok %s, %d recorded, %d queued, %d held, %d dropped",
+			state, atomic.LoadUint64(&eventSeq), len(s.queue), held, dropped)
+	case "flush":
+		s.rec.flush()
+		return "ok flushed"
+	}
+	return fmt.Sprintf("error unknown command %q", command)
+}
+
+// close stops accepting commands, disconnects clients and waits for
+// commands in progress to finish, then removes the socket file.
+func (s *controlServer) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.listener.Close()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.active.Wait()
+	os.Remove(s.path)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Records that never pass through the event queue, such as the removals
+// reported by track_moves, are also held back while paused.
+func TestPauseHoldsRecords(t *testing.T) {
+	for _, buffer := range []bool{false, true} {
+		storage := filepath.Join(t.TempDir(), "events.ndjson")
+		p := newTestProcessor(t, Config{StorageLocation: storage, Format: formatNDJSON}, []string{"/w"})
+		p.pause = newPauser(buffer)
+		p.moves = newMoveTracker(0, func(path string, id fileID) {
+			p.record(FileData{Path: path, Event: eventRemove, Inode: id.inode, Device: id.device})
+		})
+		p.moves.remember("/w/moved.txt", fileID{1, 1})
+
+		p.pause.pause()
+		p.record(FileData{Path: "/w/gone.txt", Event: eventRemove})
+		p.moves.renamed("/w/moved.txt")
+		p.moves.flush()
+		p.rec.flush()
+		if records, err := readRecords(storage, formatNDJSON); err != nil || len(records) != 0 {
+			t.Fatalf("buffer %v: recorded %+v while paused, err = %v", buffer, records, err)
+		}
+		_, held, dropped := p.pause.state()
+		if buffer && (held != 2 || dropped != 0) || !buffer && (held != 0 || dropped != 2) {
+			t.Errorf("buffer %v: %d held, %d dropped", buffer, held, dropped)
+		}
+
+		_, records := p.pause.resume()
+		for _, fileData := range records {
+			p.record(fileData)
+		}
+		p.rec.close()
+		got, err := readRecords(storage, formatNDJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := 0
+		if buffer {
+			want = 2
+		}
+		if len(got) != want {
+			t.Errorf("buffer %v: recorded %d records after resume, want %d", buffer, len(got), want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+//go:build unix
+
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// socketDir returns a short temporary directory, as socket paths are
+// limited to about 100 bytes.
+func socketDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "ctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestControlSocketOwnerOnly(t *testing.T) {
+	path := filepath.Join(socketDir(t), "control.sock")
+	s, err := startControlServer(path, newPauser(false), nil, nil, func(fileEvent) {}, func(FileData) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != controlSocketMode {
+		t.Errorf("socket mode = %o, want %o", mode, controlSocketMode)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("pause\n")); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := bufio.NewReader(conn).ReadString('\n'); err != nil || !strings.HasPrefix(reply, "ok") {
+		t.Errorf("pause reply = %q, err = %v", reply, err)
+	}
+}
+
+func TestControlSocketReplacesOnlyStaleSockets(t *testing.T) {
+	dir := socketDir(t)
+
+	stale := filepath.Join(dir, "stale.sock")
+	listener, err := net.Listen("unix", stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+	s, err := startControlServer(stale, newPauser(false), nil, nil, func(fileEvent) {}, func(FileData) {})
+	if err != nil {
+		t.Fatalf("stale socket not replaced: %v", err)
+	}
+	s.close()
+
+	regular := filepath.Join(dir, "regular.sock")
+	if err := ioutil.WriteFile(regular, []byte("keep"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := startControlServer(regular, newPauser(false), nil, nil, func(fileEvent) {}, func(FileData) {}); err == nil {
+		s.close()
+		t.Fatal("listened over a regular file")
+	}
+	if data, err := ioutil.ReadFile(regular); err != nil || string(data) != "keep" {
+		t.Errorf("regular file = %q, err = %v; want it left alone", data, err)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csvHeader names the columns written by csvRow, in order.
+var csvHeader = []string{
+	"seq", "path", "watch_root", "size", "key", "event", "mode",
+	"content_type", "inner_content_type", "tags",
+	"checksum", "tail_checksum", "inode", "device", "metadata", "host", "time", "from", "to", "sampled", "truncated", "link_target", "fuzzy_hash", "hard_links",
+}
+
+// csvRow flattens fileData into the columns of csvHeader. Tags and
+// metadata are stored as JSON objects.
+func csvRow(fileData FileData) []string {
+	var tags, metadata string
+	if len(fileData.Tags) > 0 {
+		encoded, _ := json.Marshal(fileData.Tags)
+		tags = string(encoded)
+	}
+	if len(fileData.Metadata) > 0 {
+		encoded, _ := json.Marshal(fileData.Metadata)
+		metadata = string(encoded)
+	}
+	return []string{
+		strconv.FormatUint(fileData.Seq, 10),
+		fileData.Path,
+		fileData.WatchRoot,
+		strconv.FormatInt(int64(fileData.Size), 10),
+		fileData.Key,
+		fileData.Event,
+		fileData.Mode,
+		fileData.ContentType,
+		fileData.InnerContentType,
+		tags,
+		fileData.Checksum,
+		fileData.TailChecksum,
+		strconv.FormatUint(fileData.Inode, 10),
+		strconv.FormatUint(fileData.Device, 10),
+		metadata,
+		fileData.Host,
+		formatRecordTime(fileData.Time),
+		fileData.From,
+		fileData.To,
+		strconv.FormatBool(fileData.Sampled),
+		strconv.FormatBool(fileData.Truncated),
+		fileData.LinkTarget,
+		fileData.FuzzyHash,
+		strconv.FormatUint(fileData.HardLinks, 10),
+	}
+}
+
+// readCSV parses a file written by appendCSV. Columns are located by the
+// header, so files written before a column was added still load.
+func readCSV(storageLocation string) ([]FileData, error) {
+	f, err := os.Open(storageLocation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read storage file: %v", err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse storage file: %v", err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	column := make(map[string]int, len(rows[0]))
+	for i, name := range rows[0] {
+		column[name] = i
+	}
+
+	records := make([]FileData, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		get := func(name string) string {
+			if i, ok := column[name]; ok && i < len(row) {
+				return row[i]
+			}
+			return ""
+		}
+		fileData := FileData{
+			Path:             get("path"),
+			WatchRoot:        get("watch_root"),
+			Key:              get("key"),
+			Event:            get("event"),
+			Mode:             get("mode"),
+			ContentType:      get("content_type"),
+			InnerContentType: get("inner_content_type"),
+			Checksum:         get("checksum"),
+			TailChecksum:     get("tail_checksum"),
+			Host:             get("host"),
+			From:             get("from"),
+			To:               get("to"),
+			LinkTarget:       get("link_target"),
+			FuzzyHash:        get("fuzzy_hash"),
+		}
+		fileData.Seq, _ = strconv.ParseUint(get("seq"), 10, 64)
+		size, _ := strconv.ParseInt(get("size"), 10, 64)
+		fileData.Size = fileSize(size)
+		fileData.Inode, _ = strconv.ParseUint(get("inode"), 10, 64)
+		fileData.Device, _ = strconv.ParseUint(get("device"), 10, 64)
+		fileData.HardLinks, _ = strconv.ParseUint(get("hard_links"), 10, 64)
+		if tags := get("tags"); tags != "" {
+			json.Unmarshal([]byte(tags), &fileData.Tags)
+		}
+		fileData.Sampled, _ = strconv.ParseBool(get("sampled"))
+		fileData.Truncated, _ = strconv.ParseBool(get("truncated"))
+		fileData.Time, _ = time.Parse(time.RFC3339Nano, get("time"))
+		if metadata := get("metadata"); metadata != "" {
+			json.Unmarshal([]byte(metadata), &fileData.Metadata)
+		}
+		records = append(records, fileData)
+	}
+	return records, nil
+}
+
+// appendCSV appends one row per record, writing the header first when the
+// file is new or empty. A file with a different header is rotated by
+// prepareCSV first. Quoting of fields containing commas, quotes or
+// newlines is handled by encoding/csv. Like ndjson, all rows are written
+// with a single append. Only FileData records have a CSV layout; others
+// are rejected by validateConfig.
+func appendCSV(storageLocation string, records []interface{}) error {
+	header, err := prepareCSV(storageLocation, time.Now())
+	if err != nil {
+		return err
+	}
+	data, err := encodeCSV(records, header)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(storageLocation, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open storage file: %v", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write storage file: %v", err)
+	}
+	return f.Close()
+}
+
+// encodeCSV encodes the FileData records as CSV rows, preceded by the
+// header when header is true.
+func encodeCSV(records []interface{}, header bool) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if header {
+		w.Write(csvHeader)
+	}
+	for _, record := range records {
+		if fileData, ok := record.(FileData); ok {
+			w.Write(csvRow(fileData))
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, fmt.Errorf("failed to encode csv: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// prepareCSV checks the header of an existing CSV file before rows are
+// appended to it. A file whose header differs from csvHeader, for example
+// one written before a column was added, is renamed with a timestamp
+// before its extension so new rows never land under the wrong columns.
+// It reports whether the header must be written.
+func prepareCSV(path string, now time.Time) (bool, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return true, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to read storage file: %v", err)
+	}
+	header, err := csv.NewReader(f).Read()
+	f.Close()
+	if err == io.EOF {
+		return true, nil
+	}
+	if err == nil && strings.Join(header, ",") == strings.Join(csvHeader, ",") {
+		return false, nil
+	}
+
+	ext := filepath.Ext(path)
+	rotated := strings.TrimSuffix(path, ext) + "." + now.Format("20060102T150405") + ext
+	if err := os.Rename(path, rotated); err != nil {
+		return false, fmt.Errorf("failed to rotate storage file with an old header: %v", err)
+	}
+	log.Printf("Storage file %s has a different csv header, moved it to %s", path, rotated)
+	return true, nil
+}
+
+// formatRecordTime formats t for a CSV column, leaving it empty for
+// records without a time.
+func formatRecordTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
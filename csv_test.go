@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCSVRoundTrip(t *testing.T) {
+	storage := filepath.Join(t.TempDir(), "storage.csv")
+	when := time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC)
+	want := []FileData{
+		{Seq: 1, Path: "/w/a,b.txt", Event: "create", Size: 3, Time: when, Tags: map[string]string{"team": "x,y"}},
+		{Seq: 2, Path: `/w/say "hi".txt`, Event: "write", Checksum: "abc", Time: when},
+		{Seq: 3, Path: "/w/line\nbreak.txt", Event: "rename", From: "/w/old,\"name\"", To: "/w/line\nbreak.txt", Time: when,
+			Metadata: map[string]interface{}{"title": "a \"quoted\", title"}},
+	}
+	for _, fileData := range want {
+		if err := appendCSV(storage, []interface{}{fileData}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "seq,path,"); n != 1 {
+		t.Errorf("header written %d times, want 1", n)
+	}
+	got, err := readCSV(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readCSV =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestAppendCSVRotatesOldHeader(t *testing.T) {
+	dir := t.TempDir()
+	storage := filepath.Join(dir, "storage.csv")
+	old := strings.Join(csvHeader[:len(csvHeader)-2], ",") + "\n1,/w/old.txt\n"
+	if err := ioutil.WriteFile(storage, []byte(old), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := appendCSV(storage, []interface{}{FileData{Seq: 2, Path: "/w/new.txt", HardLinks: 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readCSV(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Path != "/w/new.txt" || got[0].HardLinks != 1 {
+		t.Errorf("storage = %+v, want only the new record", got)
+	}
+	rotated, _ := filepath.Glob(filepath.Join(dir, "storage.*.csv"))
+	if len(rotated) != 1 {
+		t.Fatalf("rotated files = %v, want one", rotated)
+	}
+	if data, _ := ioutil.ReadFile(rotated[0]); string(data) != old {
+		t.Errorf("rotated file = %q, want %q", data, old)
+	}
+}
+
+func TestAppendPartitionRotatesOldHeader(t *testing.T) {
+	dir := t.TempDir()
+	storage := filepath.Join(dir, "part.csv")
+	if err := ioutil.WriteFile(storage, []byte("seq,path\n1,/w/old.txt\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	handles := newHandleCache(1)
+	defer handles.closeAll()
+
+	for seq := uint64(2); seq <= 3; seq++ {
+		if err := appendPartition(storage, formatCSV, handles, []interface{}{FileData{Seq: seq, Path: "/w/new.txt"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := readCSV(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Seq != 2 || got[1].Seq != 3 {
+		t.Errorf("storage = %+v, want the two new records", got)
+	}
+	if rotated, _ := filepath.Glob(filepath.Join(dir, "part.*.csv")); len(rotated) != 1 {
+		t.Errorf("rotated files = %v, want one", rotated)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// debouncer holds back events for a path until it has been quiet for the
+// debounce delay, then emits a single event. The delay comes from the
+// file's extension when debounce_by_ext has an entry for it, and from
+// debounce_interval otherwise.
+type debouncer struct {
+	global time.Duration
+	byExt  map[string]time.Duration
+	emit   func(fileEvent)
+
+	mu       sync.Mutex
+	pending  map[string]*pendingEvent // by pathKey
+	inFlight sync.WaitGroup           // fires emitting outside mu
+}
+
+// pendingEvent is the event waiting out the delay for one path.
+type pendingEvent struct {
+	ev    fileEvent
+	timer *time.Timer
+}
+
+func newDebouncer(global time.Duration, byExt map[string]time.Duration, emit func(fileEvent)) *debouncer {
+	return &debouncer{
+		global:  global,
+		byExt:   byExt,
+		emit:    emit,
+		pending: make(map[string]*pendingEvent),
+	}
+}
+
+// parseDebounceByExt converts the configured extension delays to durations.
+// Keys are normalized to lower case without a leading dot.
+func parseDebounceByExt(byExt map[string]string) (map[string]time.Duration, error) {
+	delays := make(map[string]time.Duration, len(byExt))
+	for ext, value := range byExt {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("extension %q: %v", ext, err)
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("extension %q: delay must not be negative", ext)
+		}
+		delays[normalizeExt(ext)] = d
+	}
+	return delays, nil
+}
+
+func normalizeExt(ext string) string {
+	return strings.ToLower(strings.TrimPrefix(ext, "."))
+}
+
+// delayFor returns the debounce delay for path.
+func (d *debouncer) delayFor(path string) time.Duration {
+	if delay, ok := d.byExt[normalizeExt(filepath.Ext(path))]; ok {
+		return delay
+	}
+	return d.global
+}
+
+// add schedules ev, restarting the delay if the path already has an event
+// pending. The first event name is kept, so a create followed by writes is
+// still recorded as a create.
+func (d *debouncer) add(ev fileEvent) {
+	delay := d.delayFor(ev.Path)
+	if delay == 0 {
+		d.emit(ev)
+		return
+	}
+
+	key := pathKey(ev.Path)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if p, ok := d.pending[key]; ok {
+		p.timer.Reset(delay)
+		return
+	}
+	p := &pendingEvent{ev: ev}
+	p.timer = time.AfterFunc(delay, func() { d.fire(key, p) })
+	d.pending[key] = p
+}
+
+func (d *debouncer) fire(key string, p *pendingEvent) {
+	d.mu.Lock()
+	if d.pending[key] != p {
+		// Already flushed
+		d.mu.Unlock()
+		return
+	}
+	delete(d.pending, key)
+	d.inFlight.Add(1)
+	d.mu.Unlock()
+	defer d.inFlight.Done()
+	d.emit(p.ev)
+}
+
+// flush emits every pending event immediately and waits for timers that
+// already fired to finish emitting. It is called on shutdown, after which
+// nothing is left to emit.
+func (d *debouncer) flush() {
+	d.mu.Lock()
+	pending := d.pending
+	d.pending = make(map[string]*pendingEvent)
+	d.mu.Unlock()
+
+	for _, p := range pending {
+		p.timer.Stop()
+		d.emit(p.ev)
+	}
+	d.inFlight.Wait()
+}
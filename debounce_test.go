@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDebounceDelayFallsBackToInterval(t *testing.T) {
+	byExt, err := parseDebounceByExt(map[string]string{".LOG": "5s", "csv": "0s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newDebouncer(time.Second, byExt, func(fileEvent) {})
+	tests := []struct {
+		path string
+		want time.Duration
+	}{
+		{"/w/app.log", 5 * time.Second},
+		{"/w/APP.Log", 5 * time.Second},
+		{"/w/data.csv", 0},
+		{"/w/notes.txt", time.Second},
+		{"/w/noext", time.Second},
+	}
+	for _, tt := range tests {
+		if got := d.delayFor(tt.path); got != tt.want {
+			t.Errorf("delayFor(%s) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+// flush must not return while a timer that already fired is still
+// emitting, or shutdown closes the queue under it.
+func TestDebounceFlushWaitsForFiringTimer(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	d := newDebouncer(time.Millisecond, nil, func(fileEvent) {
+		close(entered)
+		<-release
+	})
+	d.add(fileEvent{Path: "/w/a.txt", Event: eventCreate})
+	<-entered
+
+	flushed := make(chan struct{})
+	go func() {
+		d.flush()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+		t.Fatal("flush returned while an event was still being emitted")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-flushed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("flush did not return")
+	}
+}
@@ -0,0 +1,13 @@
+package main
+
+import "log"
+
+// debugLogging enables debugf output. It is set from the debug setting.
+var debugLogging bool
+
+// debugf logs like log.Printf when debug logging is enabled.
+func debugf(format string, args ...interface{}) {
+	if debugLogging {
+		log.Printf("debug: "+format, args...)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// pathIndex remembers which paths have been recorded, so repeat events for
+// a path are suppressed. With a ttl, entries expire that long after they
+// were recorded and the next event for the path is recorded again; expired
+// entries are swept out every ttl. Without one they are kept for the life
+// of the process.
+type pathIndex struct {
+	ttl time.Duration
+
+	mu   sync.Mutex
+	seen map[string]time.Time // by pathKey, when last recorded
+
+	done    chan struct{}
+	stopped sync.WaitGroup
+}
+
+func newPathIndex(ttl time.Duration) *pathIndex {
+	idx := &pathIndex{ttl: ttl, seen: make(map[string]time.Time), done: make(chan struct{})}
+	if ttl > 0 {
+		idx.stopped.Add(1)
+		go idx.sweepEvery(ttl)
+	}
+	return idx
+}
+
+// first reports whether path has not been recorded within the ttl, noting
+// it as recorded now if so.
+func (idx *pathIndex) first(path string, now time.Time) bool {
+	key := pathKey(path)
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if at, ok := idx.seen[key]; ok && (idx.ttl == 0 || now.Sub(at) < idx.ttl) {
+		return false
+	}
+	idx.seen[key] = now
+	return true
+}
+
+func (idx *pathIndex) sweepEvery(interval time.Duration) {
+	defer idx.stopped.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			idx.sweep(now)
+		case <-idx.done:
+			return
+		}
+	}
+}
+
+// sweep drops the entries that have expired by now.
+func (idx *pathIndex) sweep(now time.Time) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for key, at := range idx.seen {
+		if now.Sub(at) >= idx.ttl {
+			delete(idx.seen, key)
+		}
+	}
+}
+
+// close stops the sweeper.
+func (idx *pathIndex) close() {
+	close(idx.done)
+	idx.stopped.Wait()
+}
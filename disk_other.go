@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package main
+
+// freeDiskBytes is not implemented on this platform, so min_free_disk_bytes
+// has no effect.
+func freeDiskBytes(path string) (free uint64, ok bool, err error) {
+	return 0, false, nil
+}
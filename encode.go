@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+)
+
+// Sink formats accepted by a sink's format setting.
+const (
+	sinkFormatJSON   = "json"
+	sinkFormatPretty = "pretty"
+	sinkFormatNDJSON = "ndjson"
+	sinkFormatCSV    = "csv"
+
+	sinkFormatCloudEvents = "cloudevents"
+)
+
+// sinkEncoding turns a record into the bytes a sink sends, along with their
+// MIME type.
+type sinkEncoding struct {
+	format      string
+	contentType string
+	encode      func(FileData) ([]byte, error)
+}
+
+// newEncoding returns the sinkEncoding for format, or for fallback when format
+// is empty. eventType prefixes the CloudEvents type attribute.
+func newEncoding(format, fallback, eventType string) (sinkEncoding, error) {
+	if format == "" {
+		format = fallback
+	}
+	switch format {
+	case sinkFormatJSON:
+		return sinkEncoding{format, "application/json", func(fileData FileData) ([]byte, error) {
+			return json.Marshal(fileData)
+		}}, nil
+	case sinkFormatPretty:
+		return sinkEncoding{format, "application/json", func(fileData FileData) ([]byte, error) {
+			return json.MarshalIndent(fileData, "", "  ")
+		}}, nil
+	case sinkFormatNDJSON:
+		return sinkEncoding{format, "application/x-ndjson", func(fileData FileData) ([]byte, error) {
+			line, err := json.Marshal(fileData)
+			return append(line, '\n'), err
+		}}, nil
+	case sinkFormatCSV:
+		return sinkEncoding{format, "text/csv", encodeCSVRow}, nil
+	case sinkFormatCloudEvents:
+		return sinkEncoding{format, "application/cloudevents+json", cloudEventsEncoder(eventType)}, nil
+	}
+	return sinkEncoding{}, fmt.Errorf("unknown format %q", format)
+}
+
+// encodeCSVRow encodes fileData as a single CSV row in csvHeader order.
+func encodeCSVRow(fileData FileData) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(csvRow(fileData))
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
@@ -0,0 +1,80 @@
+package main
+
+import "github.com/fsnotify/fsnotify"
+
+// Event names used in the record_events setting and the event field.
+const (
+	eventCreate = "create"
+	eventWrite  = "write"
+	eventChmod  = "chmod"
+
+	// eventSpecial marks a pipe, device or socket; it is not selectable
+	// in record_events.
+	eventSpecial = "special"
+)
+
+// defaultRecordEvents preserves the original behaviour of recording
+// creations and writes only.
+var defaultRecordEvents = []string{eventCreate, eventWrite}
+
+// fileEvent is a path queued for processing together with what happened to it.
+type fileEvent struct {
+	Path  string
+	Event string
+
+	// Marker is the completion marker that triggered the event, if any.
+	Marker string
+	// From is the old path of a move.
+	From string
+}
+
+// eventName maps an fsnotify operation to the event name it is recorded
+// under, or "" when the operation is never recorded.
+func eventName(op fsnotify.Op) string {
+	switch {
+	case op&fsnotify.Create == fsnotify.Create:
+		return eventCreate
+	case op&fsnotify.Write == fsnotify.Write:
+		return eventWrite
+	case op&fsnotify.Chmod == fsnotify.Chmod:
+		return eventChmod
+	}
+	return ""
+}
+
+// recordedEvent returns the name op is recorded under given the selected
+// events, or "" when none of its operations is selected. An op carrying
+// several operations, such as Create|Write, is recorded under the first
+// selected one in create, write, chmod order.
+func recordedEvent(op fsnotify.Op, selected map[string]bool) string {
+	for _, candidate := range []fsnotify.Op{fsnotify.Create, fsnotify.Write, fsnotify.Chmod} {
+		if op&candidate == candidate {
+			if name := eventName(candidate); selected[name] {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// knownEvent reports whether name can appear in record_events.
+func knownEvent(name string) bool {
+	switch name {
+	case eventCreate, eventWrite, eventChmod:
+		return true
+	}
+	return false
+}
+
+// eventSet returns the set of event names to record, falling back to
+// defaultRecordEvents when none are configured.
+func eventSet(names []string) map[string]bool {
+	if len(names) == 0 {
+		names = defaultRecordEvents
+	}
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return set
+}
@@ -0,0 +1,25 @@
+//go:build darwin
+
+package main
+
+import (
+	"os"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// platformEvent adjusts for the kqueue backend, which coalesces an atomic
+// save (write to a temporary file, then rename it over the original) into
+// a Rename or Create on the saved path. When that path now exists as a
+// regular file the event also counts as a write, so saves are recorded
+// even when only write is selected in record_events. Rename is kept, since
+// the same event may be half of a real move onto an existing file.
+func platformEvent(event fsnotify.Event) fsnotify.Event {
+	if event.Op&(fsnotify.Rename|fsnotify.Create) == 0 {
+		return event
+	}
+	if info, err := os.Lstat(event.Name); err == nil && info.Mode().IsRegular() {
+		event.Op |= fsnotify.Write
+	}
+	return event
+}
@@ -0,0 +1,119 @@
+//go:build darwin
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// An editor's atomic save writes a temporary file and renames it over the
+// target. kqueue reports the target as renamed or created, and only write
+// is selected: the save must still be recorded, once, as a write.
+func TestAtomicSaveRecordedAsWrite(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "notes.txt")
+	tmp := filepath.Join(dir, ".notes.txt.swp")
+	if err := ioutil.WriteFile(target, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(tmp, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		t.Fatal(err)
+	}
+
+	selected := eventSet([]string{eventWrite})
+	for _, tt := range []struct {
+		name   string
+		events []fsnotify.Event
+	}{
+		{"rename over target", []fsnotify.Event{
+			{Name: tmp, Op: fsnotify.Create},
+			{Name: tmp, Op: fsnotify.Write},
+			{Name: tmp, Op: fsnotify.Rename},
+			{Name: target, Op: fsnotify.Rename},
+		}},
+		{"target recreated", []fsnotify.Event{
+			{Name: tmp, Op: fsnotify.Create},
+			{Name: tmp, Op: fsnotify.Rename},
+			{Name: target, Op: fsnotify.Create},
+		}},
+	} {
+		var recorded []string
+		for _, event := range tt.events {
+			event = platformEvent(event)
+			if name := recordedEvent(event.Op, selected); name != "" && event.Name == target {
+				recorded = append(recorded, name)
+			}
+		}
+		if !equalStrings(recorded, []string{eventWrite}) {
+			t.Errorf("%s: target recorded as %v, want a single write", tt.name, recorded)
+		}
+	}
+}
+
+// A rename that moved the file away is left alone, and a rename onto an
+// existing file keeps its Rename alongside the added Write.
+func TestPlatformEventKeepsRename(t *testing.T) {
+	dir := t.TempDir()
+	gone := filepath.Join(dir, "gone.txt")
+	if event := platformEvent(fsnotify.Event{Name: gone, Op: fsnotify.Rename}); event.Op != fsnotify.Rename {
+		t.Errorf("missing file: op = %v, want Rename", event.Op)
+	}
+	present := filepath.Join(dir, "present.txt")
+	if err := ioutil.WriteFile(present, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if event := platformEvent(fsnotify.Event{Name: present, Op: fsnotify.Rename}); event.Op != fsnotify.Rename|fsnotify.Write {
+		t.Errorf("existing file: op = %v, want Rename|Write", event.Op)
+	}
+}
+
+// A plain os.Rename keeps its Rename through platformEvent, so track_moves
+// still pairs it with the create of the new name into one move.
+func TestPlainRenameRecordedAsMove(t *testing.T) {
+	dir := t.TempDir()
+	from := filepath.Join(dir, "a.txt")
+	to := filepath.Join(dir, "b.txt")
+	if err := ioutil.WriteFile(from, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Lstat(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var removed []string
+	moves := newMoveTracker(time.Minute, func(path string, id fileID) {
+		removed = append(removed, path)
+	})
+	inode, device := fileIdentity(info)
+	moves.remember(from, fileID{inode, device})
+	if err := os.Rename(from, to); err != nil {
+		t.Fatal(err)
+	}
+
+	var recorded []fileEvent
+	for _, event := range []fsnotify.Event{
+		{Name: from, Op: fsnotify.Rename},
+		{Name: to, Op: fsnotify.Create},
+	} {
+		if ev, ok := moves.observe(platformEvent(event)); ok {
+			recorded = append(recorded, ev)
+		}
+	}
+	moves.flush()
+	want := fileEvent{Path: to, Event: eventMove, From: from}
+	if len(recorded) != 1 || recorded[0] != want {
+		t.Errorf("recorded %+v, want %+v", recorded, want)
+	}
+	if len(removed) != 0 {
+		t.Errorf("removed %v, want none", removed)
+	}
+}
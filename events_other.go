@@ -0,0 +1,11 @@
+//go:build !darwin
+
+package main
+
+import "github.com/fsnotify/fsnotify"
+
+// platformEvent returns event unchanged; only the macOS backend needs its
+// events adjusted.
+func platformEvent(event fsnotify.Event) fsnotify.Event {
+	return event
+}
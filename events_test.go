@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestRecordedEvent(t *testing.T) {
+	for _, tt := range []struct {
+		op       fsnotify.Op
+		selected []string
+		want     string
+	}{
+		{fsnotify.Create | fsnotify.Write, nil, eventCreate},
+		{fsnotify.Create | fsnotify.Write, []string{eventWrite}, eventWrite},
+		{fsnotify.Write | fsnotify.Chmod, []string{eventChmod}, eventChmod},
+		{fsnotify.Chmod, nil, ""},
+		{fsnotify.Rename, []string{eventCreate, eventWrite, eventChmod}, ""},
+	} {
+		if got := recordedEvent(tt.op, eventSet(tt.selected)); got != tt.want {
+			t.Errorf("recordedEvent(%v, %v) = %q, want %q", tt.op, tt.selected, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultExecTimeout     = 30 * time.Second
+	defaultExecConcurrency = 4
+)
+
+// execFailures counts commands that failed to start, timed out or exited
+// non-zero.
+var execFailures uint64
+
+// commandRunner runs exec_command for every recorded event. Each argument
+// is a text/template executed against the FileData. The command is run
+// directly, not through a shell, so file names cannot inject shell syntax;
+// the record is also passed in FILE_* environment variables.
+type commandRunner struct {
+	args    []*template.Template
+	timeout time.Duration
+	slots   semaphore
+}
+
+// newCommandRunner parses the command templates. It returns nil when no
+// command is configured.
+func newCommandRunner(config Config) (*commandRunner, error) {
+	if len(config.ExecCommand) == 0 {
+		return nil, nil
+	}
+	args := make([]*template.Template, len(config.ExecCommand))
+	for i, arg := range config.ExecCommand {
+		t, err := template.New("exec").Option("missingkey=error").Parse(arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d: %v", i, err)
+		}
+		if err := t.Execute(&bytes.Buffer{}, FileData{}); err != nil {
+			return nil, fmt.Errorf("argument %d: %v", i, err)
+		}
+		args[i] = t
+	}
+
+	timeout := config.ExecTimeout
+	if timeout == 0 {
+		timeout = defaultExecTimeout
+	}
+	concurrency := config.ExecConcurrency
+	if concurrency == 0 {
+		concurrency = defaultExecConcurrency
+	}
+	return &commandRunner{args: args, timeout: timeout, slots: newSemaphore(concurrency)}, nil
+}
+
+// run executes the command for fileData and logs its output. It blocks
+// while exec_concurrency commands are already running, which holds back
+// the worker rather than letting commands pile up.
+func (c *commandRunner) run(fileData FileData) {
+	argv := make([]string, len(c.args))
+	for i, t := range c.args {
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, fileData); err != nil {
+			log.Printf("Failed to expand exec_command for %s: %v", fileData.Path, err)
+			atomic.AddUint64(&execFailures, 1)
+			return
+		}
+		argv[i] = buf.String()
+	}
+
+	c.slots.acquire()
+	defer c.slots.release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Env = append(os.Environ(),
+		"FILE_PATH="+fileData.Path,
+		"FILE_WATCH_ROOT="+fileData.WatchRoot,
+		"FILE_EVENT="+fileData.Event,
+		"FILE_SIZE="+strconv.FormatInt(int64(fileData.Size), 10),
+		"FILE_SEQ="+strconv.FormatUint(fileData.Seq, 10),
+		"FILE_HOST="+fileData.Host,
+	)
+	output, err := cmd.CombinedOutput()
+	if out := strings.TrimSpace(string(output)); out != "" {
+		log.Printf("exec_command for %s: %s", fileData.Path, out)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		log.Printf("exec_command for %s timed out after %v", fileData.Path, c.timeout)
+		atomic.AddUint64(&execFailures, 1)
+		return
+	}
+	if err != nil {
+		log.Printf("exec_command for %s failed: %v", fileData.Path, err)
+		atomic.AddUint64(&execFailures, 1)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// foldCase makes path matching and path-keyed state case-insensitive. It
+// is set from case_insensitive.
+var foldCase bool
+
+// defaultCaseInsensitive reports whether the platform's usual filesystems
+// ignore case, as on macOS and Windows.
+func defaultCaseInsensitive() bool {
+	return runtime.GOOS == "darwin" || runtime.GOOS == "windows"
+}
+
+// pathKey returns the form of path used for matching and as a map key.
+// Recorded paths keep their original case.
+func pathKey(path string) string {
+	if foldCase {
+		return strings.ToLower(path)
+	}
+	return path
+}
+
+// compilePatterns compiles each regular expression, reporting the first
+// pattern that fails to compile. Patterns ignore case when foldCase is set.
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		expr := pattern
+		if foldCase {
+			expr = "(?i)" + expr
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q: %v", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// matchesAny reports whether path matches at least one of the patterns.
+func matchesAny(patterns []*regexp.Regexp, path string) bool {
+	for _, re := range patterns {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
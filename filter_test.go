@@ -0,0 +1,68 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDefaultCaseInsensitive(t *testing.T) {
+	want := runtime.GOOS == "darwin" || runtime.GOOS == "windows"
+	if got := defaultCaseInsensitive(); got != want {
+		t.Errorf("defaultCaseInsensitive() on %s = %v, want %v", runtime.GOOS, got, want)
+	}
+}
+
+// With case_insensitive on, A.txt and a.txt share one debounce, dedupe and
+// checksum entry; with it off they stay distinct.
+func TestPathKeyFoldsCase(t *testing.T) {
+	defer func(saved bool) { foldCase = saved }(foldCase)
+	const upper, lower = "/w/A.txt", "/w/a.txt"
+
+	for _, fold := range []bool{true, false} {
+		foldCase = fold
+		want := 2
+		if fold {
+			want = 1
+		}
+
+		if same := pathKey(upper) == pathKey(lower); same != fold {
+			t.Errorf("fold %v: pathKey match = %v", fold, same)
+		}
+
+		var mu sync.Mutex
+		var emitted []string
+		d := newDebouncer(time.Hour, nil, func(ev fileEvent) {
+			mu.Lock()
+			emitted = append(emitted, ev.Path)
+			mu.Unlock()
+		})
+		d.add(fileEvent{Path: upper, Event: eventCreate})
+		d.add(fileEvent{Path: lower, Event: eventWrite})
+		d.flush()
+		if len(emitted) != want {
+			t.Errorf("fold %v: debouncer emitted %v, want %d events", fold, emitted, want)
+		} else if fold && emitted[0] != upper {
+			t.Errorf("debouncer emitted %s, want the original %s", emitted[0], upper)
+		}
+
+		idx := newPathIndex(0)
+		idx.first(upper, time.Now())
+		if got := idx.first(lower, time.Now()); got != !fold {
+			t.Errorf("fold %v: dedupe first(%s) = %v", fold, lower, got)
+		}
+		idx.close()
+
+		c := newChecksummer(true)
+		c.save(upper, hashState{size: 1})
+		c.save(lower, hashState{size: 2})
+		if len(c.states) != want {
+			t.Errorf("fold %v: checksummer kept %d states, want %d", fold, len(c.states), want)
+		}
+		c.forget(upper)
+		if len(c.states) != want-1 {
+			t.Errorf("fold %v: forget(%s) left %d states, want %d", fold, upper, len(c.states), want-1)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// Size units available to filter expressions, e.g. size > 1 * MB.
+const (
+	unitKB = 1 << 10
+	unitMB = 1 << 20
+	unitGB = 1 << 30
+)
+
+// recordFilter decides from a compiled filter expression whether a record
+// is kept.
+type recordFilter struct {
+	program *vm.Program
+}
+
+// filterEnv returns the variables a filter expression can refer to for
+// fileData. Fields missing from a record are zero values.
+func filterEnv(fileData FileData) map[string]interface{} {
+	tags := fileData.Tags
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	return map[string]interface{}{
+		"path":         fileData.Path,
+		"name":         filepath.Base(fileData.Path),
+		"ext":          strings.TrimPrefix(filepath.Ext(fileData.Path), "."),
+		"dir":          filepath.Dir(fileData.Path),
+		"watch_root":   fileData.WatchRoot,
+		"size":         int64(fileData.Size),
+		"event":        fileData.Event,
+		"mode":         fileData.Mode,
+		"key":          fileData.Key,
+		"content_type": fileData.ContentType,
+		"checksum":     fileData.Checksum,
+		"host":         fileData.Host,
+		"tags":         tags,
+		"KB":           unitKB,
+		"MB":           unitMB,
+		"GB":           unitGB,
+	}
+}
+
+// compileFilter compiles source, which must evaluate to a boolean. An
+// empty source yields a nil filter that keeps everything.
+func compileFilter(source string) (*recordFilter, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, nil
+	}
+	program, err := expr.Compile(source, expr.Env(filterEnv(FileData{})), expr.AsBool())
+	if err != nil {
+		return nil, err
+	}
+	return &recordFilter{program: program}, nil
+}
+
+// match reports whether fileData passes the filter.
+func (f *recordFilter) match(fileData FileData) (bool, error) {
+	out, err := expr.Run(f.program, filterEnv(fileData))
+	if err != nil {
+		return false, err
+	}
+	keep, ok := out.(bool)
+	if !ok {
+		return false, fmt.Errorf("filter returned %T, not a boolean", out)
+	}
+	return keep, nil
+}
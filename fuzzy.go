@@ -0,0 +1,106 @@
+package main
+
+import "fmt"
+
+const (
+	// fuzzyMinSize is the smallest file given a fuzzy hash; smaller files
+	// produce too few pieces to compare meaningfully.
+	fuzzyMinSize = 4096
+
+	// fuzzySigLen caps each signature, as in ssdeep.
+	fuzzySigLen = 64
+
+	fuzzyWindow   = 7
+	fuzzyMinBlock = 3
+)
+
+const fuzzyAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// fuzzyHasher computes a context-triggered piecewise hash in the style of
+// ssdeep. A rolling hash over the last few bytes picks piece boundaries
+// from the content itself, so an insertion only changes the pieces around
+// it and similar files share most of their signature. Each piece
+// contributes one character. Two signatures are kept, at the block size and
+// at twice it, so files whose sizes straddle a block size boundary can still
+// be compared. It is an io.Writer so it can be fed with copyHash.
+type fuzzyHasher struct {
+	block uint32
+
+	window     [fuzzyWindow]byte
+	pos        int
+	h1, h2, h3 uint32
+	piece1     uint32
+	piece2     uint32
+	sig1, sig2 []byte
+}
+
+// newFuzzyHasher picks the block size for a file of size bytes so each
+// signature ends up close to fuzzySigLen characters.
+func newFuzzyHasher(size int64) *fuzzyHasher {
+	block := uint32(fuzzyMinBlock)
+	for int64(block)*fuzzySigLen < size {
+		block *= 2
+	}
+	return &fuzzyHasher{block: block, piece1: fnvOffset, piece2: fnvOffset}
+}
+
+const (
+	fnvOffset = 0x28021967
+	fnvPrime  = 0x01000193
+)
+
+func (f *fuzzyHasher) Write(p []byte) (int, error) {
+	for _, c := range p {
+		f.roll(c)
+		f.piece1 = f.piece1*fnvPrime ^ uint32(c)
+		f.piece2 = f.piece2*fnvPrime ^ uint32(c)
+		sum := f.h1 + f.h2 + f.h3
+		if sum%f.block == f.block-1 && len(f.sig1) < fuzzySigLen-1 {
+			f.sig1 = append(f.sig1, fuzzyAlphabet[f.piece1%64])
+			f.piece1 = fnvOffset
+		}
+		if sum%(2*f.block) == 2*f.block-1 && len(f.sig2) < fuzzySigLen/2-1 {
+			f.sig2 = append(f.sig2, fuzzyAlphabet[f.piece2%64])
+			f.piece2 = fnvOffset
+		}
+	}
+	return len(p), nil
+}
+
+// roll adds c to the rolling hash over the last fuzzyWindow bytes.
+func (f *fuzzyHasher) roll(c byte) {
+	f.h2 -= f.h1
+	f.h2 += fuzzyWindow * uint32(c)
+	f.h1 += uint32(c)
+	f.h1 -= uint32(f.window[f.pos])
+	f.window[f.pos] = c
+	f.pos = (f.pos + 1) % fuzzyWindow
+	f.h3 = f.h3<<5 ^ uint32(c)
+}
+
+// sum returns the hash as block:signature:double-block-signature. The
+// last piece, which ends at the end of the file rather than at a boundary,
+// adds a final character to each signature.
+func (f *fuzzyHasher) sum() string {
+	sig1 := append(f.sig1, fuzzyAlphabet[f.piece1%64])
+	sig2 := append(f.sig2, fuzzyAlphabet[f.piece2%64])
+	return fmt.Sprintf("%d:%s:%s", f.block, sig1, sig2)
+}
+
+// fuzzyHashFile returns the fuzzy hash of the file at path, or "" for
+// files smaller than fuzzyMinSize.
+func fuzzyHashFile(path string, size int64) (string, error) {
+	if size < fuzzyMinSize {
+		return "", nil
+	}
+	f, err := openWatched(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := newFuzzyHasher(size)
+	if _, err := copyHash(h, f); err != nil {
+		return "", err
+	}
+	return h.sum(), nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// countOpens counts the files opened through openWatched until the test
+// ends.
+func countOpens(t *testing.T) *int32 {
+	var opens int32
+	openFile = func(name string) (*os.File, error) {
+		atomic.AddInt32(&opens, 1)
+		return os.Open(name)
+	}
+	t.Cleanup(func() { openFile = os.Open })
+	return &opens
+}
+
+// A fuzzy hash fed by the checksum's read matches one read on its own,
+// and costs no extra open.
+func TestChecksumFeedsFuzzyHash(t *testing.T) {
+	for _, incremental := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "data.log")
+		content := bytes.Repeat([]byte("some log line with words\n"), 1000)
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		c := newChecksummer(incremental)
+
+		for round := 0; round < 2; round++ {
+			if round == 1 {
+				// Appended: the incremental checksum reads only the tail
+				appendTo(t, path, []byte("one more line\n"))
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := fuzzyHashFile(path, info.Size())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			opens := countOpens(t)
+			fuzzy := newFuzzyHasher(info.Size())
+			if _, _, err := c.checksum(path, info, fuzzy); err != nil {
+				t.Fatal(err)
+			}
+			if got := fuzzy.sum(); got != want {
+				t.Errorf("incremental %v, round %d: fuzzy hash = %s, want %s", incremental, round, got, want)
+			}
+			if n := atomic.LoadInt32(opens); n != 1 {
+				t.Errorf("incremental %v, round %d: opened the file %d times, want once", incremental, round, n)
+			}
+			openFile = os.Open
+		}
+	}
+}
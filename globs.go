@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// globFilter applies include_patterns and exclude_patterns. Patterns are
+// matched against the path relative to its watch root, slash-separated. A
+// pattern without a slash matches the file name at any depth, so "*.tmp"
+// excludes temporary files everywhere. A "**" segment matches any number
+// of directories, including none: ".git/**" matches .git and everything
+// below it, "**/node_modules/**" matches node_modules at any depth. In
+// completion-marker mode a marker is matched as the file it marks.
+type globFilter struct {
+	roots   []string
+	markers string
+	include [][]string // split into segments
+	exclude [][]string
+}
+
+// compileGlobs splits each pattern into segments, reporting the first
+// malformed one. Patterns ignore case when foldCase is set.
+func compileGlobs(patterns []string) ([][]string, error) {
+	compiled := make([][]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		pattern = strings.Trim(filepath.ToSlash(pattern), "/")
+		if pattern == "" {
+			return nil, fmt.Errorf("empty pattern")
+		}
+		if foldCase {
+			pattern = strings.ToLower(pattern)
+		}
+		segments := strings.Split(pattern, "/")
+		for _, seg := range segments {
+			if _, err := path.Match(seg, ""); err != nil {
+				return nil, fmt.Errorf("pattern %q: %v", pattern, err)
+			}
+		}
+		if len(segments) == 1 {
+			segments = []string{"**", segments[0]}
+		}
+		compiled = append(compiled, segments)
+	}
+	return compiled, nil
+}
+
+func newGlobFilter(roots, include, exclude []string, markers string) (*globFilter, error) {
+	inc, err := compileGlobs(include)
+	if err != nil {
+		return nil, fmt.Errorf("include_patterns: %v", err)
+	}
+	exc, err := compileGlobs(exclude)
+	if err != nil {
+		return nil, fmt.Errorf("exclude_patterns: %v", err)
+	}
+	return &globFilter{roots: roots, markers: markers, include: inc, exclude: exc}, nil
+}
+
+// excluded reports whether p matches an exclude pattern. A watch root
+// itself is never excluded.
+func (f *globFilter) excluded(p string) bool {
+	if len(f.exclude) == 0 {
+		return false
+	}
+	name := f.segments(p)
+	return name != nil && matchesGlobs(f.exclude, name)
+}
+
+// included reports whether the file p matches an include pattern, or
+// whether no include patterns are set. Directories are not filtered by
+// include patterns, so callers check files only.
+func (f *globFilter) included(p string) bool {
+	return len(f.include) == 0 || matchesGlobs(f.include, f.segments(p))
+}
+
+// skipEvent reports whether event is dropped by the patterns. Events on
+// directories are let through so new ones can be watched. A path matching
+// no include pattern is statted to find out whether it is one; a removed
+// or renamed directory can no longer be statted, so watched, when not nil,
+// reports the directories being watched and their removal is let through
+// to be forgotten.
+func (f *globFilter) skipEvent(event fsnotify.Event, watched func(dir string) bool) bool {
+	p := event.Name
+	if f.excluded(p) {
+		return true
+	}
+	if f.included(p) {
+		return false
+	}
+	if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 && watched != nil && watched(p) {
+		return false
+	}
+	info, err := os.Stat(p)
+	return err != nil || !info.IsDir()
+}
+
+// segments returns p relative to its watch root, split on slashes, or nil
+// for the root itself. A completion marker yields the segments of the file
+// it marks.
+func (f *globFilter) segments(p string) []string {
+	if f.markers != "" && strings.HasSuffix(p, f.markers) && len(p) > len(f.markers) {
+		p = strings.TrimSuffix(p, f.markers)
+	}
+	rel := p
+	if root := rootFor(f.roots, p); root != "" {
+		if r, err := filepath.Rel(root, p); err == nil {
+			rel = r
+		}
+	}
+	if rel == "." {
+		return nil
+	}
+	return strings.Split(filepath.ToSlash(pathKey(rel)), "/")
+}
+
+// matchesGlobs reports whether name matches at least one of the patterns.
+func matchesGlobs(patterns [][]string, name []string) bool {
+	for _, pattern := range patterns {
+		if matchSegments(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
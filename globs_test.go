@@ -0,0 +1,146 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestMatchSegments(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		// No slash: the file name at any depth
+		{"*.tmp", "a.tmp", true},
+		{"*.tmp", "x/y/a.tmp", true},
+		{"*.tmp", "a.tmp.txt", false},
+		// A slash anchors the pattern to the watch root
+		{"logs/*.log", "logs/a.log", true},
+		{"logs/*.log", "x/logs/a.log", false},
+		{"logs/*.log", "logs/sub/a.log", false},
+		// ** matches any number of directories, including none
+		{".git/**", ".git", true},
+		{".git/**", ".git/HEAD", true},
+		{".git/**", ".git/refs/heads/main", true},
+		{".git/**", "sub/.git/HEAD", false},
+		{"**/node_modules/**", "node_modules", true},
+		{"**/node_modules/**", "a/b/node_modules/c/d.js", true},
+		{"**/node_modules/**", "a/node_modules_old/x", false},
+		{"src/**/*.go", "src/main.go", true},
+		{"src/**/*.go", "src/a/b/main.go", true},
+		{"src/**/*.go", "main.go", false},
+	}
+	for _, tt := range tests {
+		patterns, err := compileGlobs([]string{tt.pattern})
+		if err != nil {
+			t.Fatalf("compileGlobs(%q): %v", tt.pattern, err)
+		}
+		if got := matchesGlobs(patterns, strings.Split(tt.path, "/")); got != tt.want {
+			t.Errorf("%q against %q = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCompileGlobsRejectsMalformed(t *testing.T) {
+	for _, pattern := range []string{"[a", "", "/"} {
+		if _, err := compileGlobs([]string{pattern}); err == nil {
+			t.Errorf("compileGlobs(%q) succeeded", pattern)
+		}
+	}
+}
+
+func TestGlobFilterExcludeWins(t *testing.T) {
+	root := t.TempDir()
+	f, err := newGlobFilter([]string{root}, []string{"*.log"}, []string{"debug.log", "tmp/**"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		skip bool
+	}{
+		{"app.log", false},
+		{"sub/app.log", false},
+		{"debug.log", true},
+		{"tmp/app.log", true},
+		{"app.txt", true},
+		{"tmp", true},
+	}
+	for _, tt := range tests {
+		event := fsnotify.Event{Name: filepath.Join(root, filepath.FromSlash(tt.path)), Op: fsnotify.Create}
+		if got := f.skipEvent(event, nil); got != tt.skip {
+			t.Errorf("skipEvent(%s) = %v, want %v", tt.path, got, tt.skip)
+		}
+	}
+	if f.excluded(root) {
+		t.Error("the watch root itself is excluded")
+	}
+}
+
+// Removing a watched directory that matches no include pattern must still
+// reach trackDirectory, or the directory stays in the watch set.
+func TestSkipEventLetsRemovedDirectoryThrough(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "sub")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	made := make(chan *fakeWatcher, 1)
+	s, err := newEventSourceWith([]string{root}, fakeWatchers(nil, made))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.close()
+	if err := s.add(dir); err != nil {
+		t.Fatal(err)
+	}
+	f, err := newGlobFilter([]string{root}, []string{"*.log"}, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	event := fsnotify.Event{Name: dir, Op: fsnotify.Remove}
+	if f.skipEvent(event, s.watching) {
+		t.Fatal("removal of a watched directory was dropped")
+	}
+	trackDirectory(s, []string{root}, 0, f, event)
+	if s.watching(dir) {
+		t.Error("removed directory is still watched")
+	}
+
+	gone := fsnotify.Event{Name: filepath.Join(root, "gone.txt"), Op: fsnotify.Remove}
+	if !f.skipEvent(gone, s.watching) {
+		t.Error("removal of an unwatched, unincluded path was let through")
+	}
+}
+
+func TestGlobFilterMatchesMarkedFile(t *testing.T) {
+	root := t.TempDir()
+	f, err := newGlobFilter([]string{root}, []string{"*.csv"}, []string{"*.tmp"}, ".done")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		skip bool
+	}{
+		{"data.csv.done", false},
+		{"data.csv", false},
+		{"data.tmp.done", true},
+		{"data.txt.done", true},
+	}
+	for _, tt := range tests {
+		event := fsnotify.Event{Name: filepath.Join(root, tt.path), Op: fsnotify.Create}
+		if got := f.skipEvent(event, nil); got != tt.skip {
+			t.Errorf("skipEvent(%s) = %v, want %v", tt.path, got, tt.skip)
+		}
+	}
+}
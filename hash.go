@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sync"
+)
+
+// defaultHashBufferSize matches the buffer io.Copy allocates.
+const defaultHashBufferSize = 32 * 1024
+
+// hashBuffers hands out read buffers of hashBufferSize bytes so hashing
+// does not allocate one per file.
+var (
+	hashBufferSize = defaultHashBufferSize
+	hashBuffers    = sync.Pool{
+		New: func() interface{} {
+			buf := make([]byte, hashBufferSize)
+			return &buf
+		},
+	}
+)
+
+// setHashBufferSize sets the read buffer size used by hashFile. It must be
+// called before any hashing starts; 0 keeps the default.
+func setHashBufferSize(size int) {
+	if size > 0 {
+		hashBufferSize = size
+	}
+}
+
+// hashFile returns the hex-encoded SHA-256 of the file at path.
+func hashFile(path string) (string, error) {
+	f, err := openWatched(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := copyHash(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// copyHash feeds r into h through a pooled buffer.
+func copyHash(h io.Writer, r io.Reader) (int64, error) {
+	buf := hashBuffers.Get().(*[]byte)
+	defer hashBuffers.Put(buf)
+	return io.CopyBuffer(h, r, *buf)
+}
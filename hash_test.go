@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// BenchmarkHashFile hashes a 32 MiB file with plain io.Copy and with
+// hash_buffer_size set to a few sizes.
+func BenchmarkHashFile(b *testing.B) {
+	const size = 32 << 20
+	path := filepath.Join(b.TempDir(), "large.bin")
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("io.Copy", func(b *testing.B) {
+		b.SetBytes(size)
+		for i := 0; i < b.N; i++ {
+			f, err := os.Open(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := io.Copy(sha256.New(), f); err != nil {
+				b.Fatal(err)
+			}
+			f.Close()
+		}
+	})
+
+	defer func(saved int) {
+		hashBufferSize = saved
+		hashBuffers = sync.Pool{New: hashBuffers.New}
+	}(hashBufferSize)
+	for _, bufSize := range []int{4 << 10, 32 << 10, 256 << 10, 1 << 20} {
+		// Start each size with an empty pool so no buffer of the
+		// previous size is reused.
+		hashBufferSize = bufSize
+		hashBuffers = sync.Pool{New: hashBuffers.New}
+		b.Run(fmt.Sprintf("buffer=%dKiB", bufSize>>10), func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				if _, err := hashFile(path); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
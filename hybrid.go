@@ -0,0 +1,226 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watch strategies accepted by the watch_strategy setting.
+const (
+	watchStrategyRecursive = "recursive"
+	watchStrategyHybrid    = "hybrid"
+)
+
+const (
+	// defaultHybridSweep is how often the hybrid strategy walks every
+	// root when hybrid_sweep_interval is unset.
+	defaultHybridSweep = time.Minute
+
+	// hybridWalkDelay coalesces the events of a burst into one walk of
+	// the affected directory.
+	hybridWalkDelay = 100 * time.Millisecond
+)
+
+// hybridSource finds files matching a pattern anywhere below the roots
+// while watching only the roots and their direct subdirectories. Any event
+// in one of those directories triggers a walk of it, which reports the
+// matching files that are new or changed since the last walk. Changes
+// deeper down that touch no watched directory are found by a full sweep
+// every sweep interval.
+type hybridSource struct {
+	roots    []string
+	pattern  string
+	maxDepth int
+	sweep    time.Duration
+	notify   *eventSource
+
+	mu      sync.Mutex // serializes walks and calls to handle
+	handle  func(fsnotify.Event)
+	seen    map[string]fileState
+	pending map[string]*time.Timer
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newHybridSource(roots []string, config Config) (*hybridSource, error) {
+	notify, err := newEventSource(roots)
+	if err != nil {
+		return nil, err
+	}
+	for _, root := range roots {
+		entries, err := ioutil.ReadDir(root)
+		if err != nil {
+			log.Printf("Failed to list %s: %v", root, err)
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				notify.add(filepath.Join(root, entry.Name()))
+			}
+		}
+	}
+	sweep := config.HybridSweepInterval
+	if sweep == 0 {
+		sweep = defaultHybridSweep
+	}
+	return &hybridSource{
+		roots:    roots,
+		pattern:  config.HybridPattern,
+		maxDepth: config.MaxDepth,
+		sweep:    sweep,
+		notify:   notify,
+		seen:     make(map[string]fileState),
+		pending:  make(map[string]*time.Timer),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// run records a baseline of the matching files, then reports changes
+// until close is called. Files present at startup are not reported.
+func (s *hybridSource) run(handle func(fsnotify.Event)) {
+	for _, root := range s.roots {
+		s.walk(root, root, nil)
+	}
+	s.mu.Lock()
+	s.handle = handle
+	s.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(s.sweep)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-ticker.C:
+				for _, root := range s.roots {
+					s.walkNow(root, root)
+				}
+			}
+		}
+	}()
+
+	s.notify.run(s.changed)
+
+	// Wait for walks already scheduled, so none reports after run returns
+	s.mu.Lock()
+	for dir, timer := range s.pending {
+		timer.Stop()
+		delete(s.pending, dir)
+	}
+	s.handle = nil
+	s.mu.Unlock()
+}
+
+// changed schedules a walk of the watched directory event belongs to,
+// watching it first when it is a new direct subdirectory of a root.
+func (s *hybridSource) changed(event fsnotify.Event) {
+	root := rootFor(s.roots, event.Name)
+	if root == "" {
+		return
+	}
+	rel, err := filepath.Rel(root, event.Name)
+	if err != nil {
+		return
+	}
+	parts := strings.SplitN(rel, string(filepath.Separator), 2)
+	top := filepath.Join(root, parts[0])
+	if len(parts) == 1 {
+		info, err := os.Stat(event.Name)
+		switch {
+		case err == nil && info.IsDir():
+			s.notify.add(top)
+		case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+			s.notify.forget(top)
+		default:
+			// A file directly in the root: only it can have changed
+			top = event.Name
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if timer, ok := s.pending[top]; ok {
+		timer.Reset(hybridWalkDelay)
+		return
+	}
+	s.pending[top] = time.AfterFunc(hybridWalkDelay, func() {
+		s.mu.Lock()
+		delete(s.pending, top)
+		s.mu.Unlock()
+		s.walkNow(root, top)
+	})
+}
+
+// walkNow walks dir and reports what changed, unless the source has been
+// closed.
+func (s *hybridSource) walkNow(root, dir string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handle == nil {
+		return
+	}
+	s.walkLocked(root, dir, s.handle)
+}
+
+func (s *hybridSource) walk(root, dir string, handle func(fsnotify.Event)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.walkLocked(root, dir, handle)
+}
+
+// walkLocked compares the matching files below dir with the last walk,
+// passing creations and changes to handle when it is not nil. Files that
+// have gone are forgotten.
+func (s *hybridSource) walkLocked(root, dir string, handle func(fsnotify.Event)) {
+	found := make(map[string]bool)
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("Failed to walk %s: %v", path, err)
+			}
+			return nil
+		}
+		if info.IsDir() {
+			if !withinDepth(root, path, s.maxDepth) || nestedRoot(s.roots, root, path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ok, _ := filepath.Match(s.pattern, info.Name()); !ok {
+			return nil
+		}
+		found[path] = true
+		state := fileState{size: info.Size(), modTime: info.ModTime()}
+		prev, seen := s.seen[path]
+		s.seen[path] = state
+		switch {
+		case handle == nil:
+		case !seen:
+			handle(fsnotify.Event{Name: path, Op: fsnotify.Create})
+		case prev.size != state.size || !prev.modTime.Equal(state.modTime):
+			handle(fsnotify.Event{Name: path, Op: fsnotify.Write})
+		}
+		return nil
+	})
+
+	prefix := dir + string(filepath.Separator)
+	for path := range s.seen {
+		if (path == dir || strings.HasPrefix(path, prefix)) && !found[path] && !nestedRoot(s.roots, root, path) {
+			delete(s.seen, path)
+		}
+	}
+}
+
+func (s *hybridSource) close() {
+	s.closeOnce.Do(func() { close(s.done) })
+	s.notify.close()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// keyData is the value key_template is executed against.
+type keyData struct {
+	Path    string // full local path
+	RelPath string // path relative to the target directory, slash-separated
+	Name    string // base name
+	Ext     string // extension without the leading dot
+	Date    string // processing date as YYYY-MM-DD
+}
+
+// parseKeyTemplate parses tmpl and executes it once against empty data so
+// references to unknown fields are caught at startup. An empty tmpl yields
+// a nil template.
+func parseKeyTemplate(tmpl string) (*template.Template, error) {
+	if tmpl == "" {
+		return nil, nil
+	}
+	t, err := template.New("key").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Execute(&bytes.Buffer{}, keyData{}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// renderKey maps a local path to the key downstream consumers store it under.
+func renderKey(t *template.Template, root, path string, now time.Time) (string, error) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		rel = path
+	}
+	data := keyData{
+		Path:    path,
+		RelPath: filepath.ToSlash(rel),
+		Name:    filepath.Base(path),
+		Ext:     strings.TrimPrefix(filepath.Ext(path), "."),
+		Date:    now.Format("2006-01-02"),
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// liveSettings are the settings a remote config refresh can change while
+// the watcher runs: which events are excluded, sampled and filtered out,
+// and where records are routed. They are replaced as a whole, so each
+// event sees one consistent set.
+type liveSettings struct {
+	exclude []*regexp.Regexp
+	globs   *globFilter
+	sample  *sampler
+	filter  *recordFilter
+	routes  *router
+}
+
+// liveSettingKeys are the top-level keys newLiveSettings reads.
+var liveSettingKeys = map[string]bool{
+	"exclude_regex":    true,
+	"include_patterns": true,
+	"exclude_patterns": true,
+	"sample_rate":      true,
+	"sample_per_path":  true,
+	"filter":           true,
+	"routes":           true,
+	"default_sinks":    true,
+}
+
+// newLiveSettings compiles the live settings of config. The sinks and
+// watch roots themselves are fixed at startup.
+func newLiveSettings(config Config, sinks []Sink, roots []string) (*liveSettings, error) {
+	exclude, err := compilePatterns(config.ExcludeRegex)
+	if err != nil {
+		return nil, fmt.Errorf("exclude_regex: %v", err)
+	}
+	globs, err := newGlobFilter(roots, config.IncludePatterns, config.ExcludePatterns, config.CompletionMarkerSuffix)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := compileFilter(config.Filter)
+	if err != nil {
+		return nil, fmt.Errorf("filter: %v", err)
+	}
+	routes, err := newRouter(config.Routes, config.DefaultSinks, sinks)
+	if err != nil {
+		return nil, fmt.Errorf("routes: %v", err)
+	}
+	return &liveSettings{
+		exclude: exclude,
+		globs:   globs,
+		sample:  newSampler(config.SampleRate, config.SamplePerPath, time.Now().UnixNano()),
+		filter:  filter,
+		routes:  routes,
+	}, nil
+}
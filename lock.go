@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"syscall"
+	"time"
+)
+
+// lockedFileRetries is how many times opening a watched file is retried
+// while another process holds it locked. It is set from
+// locked_file_retries.
+var lockedFileRetries int
+
+// lockedRetryBackoff is the first delay before retrying a locked file.
+var lockedRetryBackoff = 100 * time.Millisecond
+
+// Opens failing because the process or system is out of descriptors are
+// retried fdRetries times, the wait doubling from fdRetryBackoff up to
+// maxFDRetryBackoff, so the file is read once other workers close theirs.
+// max_open_files keeps this rare, but other descriptors such as sockets
+// and the storage files count against the same limit.
+const (
+	fdRetries         = 10
+	maxFDRetryBackoff = time.Second
+)
+
+var fdRetryBackoff = 10 * time.Millisecond
+
+// openFile opens a file for reading. Tests replace it to simulate locked
+// files.
+var openFile = os.Open
+
+func isSharingViolation(err error) bool {
+	return errors.Is(err, errSharingViolation)
+}
+
+// isOutOfDescriptors reports whether err means no file descriptor was
+// free.
+func isOutOfDescriptors(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+// openWatched opens a watched file for reading, retrying with exponential
+// backoff while another process holds it locked or while no descriptor is
+// free. Every read of a watched file goes through it; a stat never fails
+// on a locked file, only an open does.
+func openWatched(path string) (*os.File, error) {
+	lockBackoff, fdBackoff := lockedRetryBackoff, fdRetryBackoff
+	var lockAttempts, fdAttempts int
+	for {
+		f, err := openFile(path)
+		switch {
+		case err == nil:
+			return f, nil
+		case isSharingViolation(err) && lockAttempts < lockedFileRetries:
+			lockAttempts++
+			log.Printf("File %s is locked by another process, retrying in %v", path, lockBackoff)
+			time.Sleep(lockBackoff)
+			lockBackoff *= 2
+		case isOutOfDescriptors(err) && fdAttempts < fdRetries:
+			fdAttempts++
+			debugf("Out of file descriptors opening %s, retrying in %v", path, fdBackoff)
+			time.Sleep(fdBackoff)
+			if fdBackoff *= 2; fdBackoff > maxFDRetryBackoff {
+				fdBackoff = maxFDRetryBackoff
+			}
+		default:
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package main
+
+import "errors"
+
+// errSharingViolation never comes from the filesystem outside Windows,
+// where files are not locked exclusively on open.
+var errSharingViolation = errors.New("sharing violation")
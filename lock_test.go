@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// lockFor makes the next n opens of any file fail with a sharing
+// violation, restoring the real open when the test ends.
+func lockFor(t *testing.T, n int) *int {
+	t.Helper()
+	calls := 0
+	openFile = func(name string) (*os.File, error) {
+		calls++
+		if calls <= n {
+			return nil, &os.PathError{Op: "open", Path: name, Err: errSharingViolation}
+		}
+		return os.Open(name)
+	}
+	retries, backoff := lockedFileRetries, lockedRetryBackoff
+	lockedRetryBackoff = time.Millisecond
+	t.Cleanup(func() {
+		openFile = os.Open
+		lockedFileRetries, lockedRetryBackoff = retries, backoff
+	})
+	return &calls
+}
+
+func TestOpenWatchedRetriesLockedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locked.txt")
+	if err := ioutil.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	calls := lockFor(t, 2)
+	lockedFileRetries = 3
+
+	sum, err := hashFile(path)
+	if err != nil {
+		t.Fatalf("hashFile: %v", err)
+	}
+	if sum == "" {
+		t.Error("hashFile returned an empty checksum")
+	}
+	if *calls != 3 {
+		t.Errorf("opened %d times, want 3", *calls)
+	}
+}
+
+func TestOpenWatchedGivesUp(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "locked.txt")
+	if err := ioutil.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	calls := lockFor(t, 10)
+	lockedFileRetries = 2
+
+	if _, err := detectContentType(path); !isSharingViolation(err) {
+		t.Fatalf("detectContentType error = %v, want a sharing violation", err)
+	}
+	if *calls != 3 {
+		t.Errorf("opened %d times, want 3", *calls)
+	}
+}
+
+// A worker that runs out of descriptors waits for another to close its
+// file instead of dropping the read.
+func TestOpenWatchedRetriesWhenOutOfDescriptors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.txt")
+	if err := ioutil.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	backoff := fdRetryBackoff
+	fdRetryBackoff = time.Millisecond
+	t.Cleanup(func() {
+		openFile = os.Open
+		fdRetryBackoff = backoff
+	})
+
+	// One slot, held by another worker: every descriptor is in use until
+	// it releases
+	openFiles := newSemaphore(1)
+	openFiles.acquire()
+	openFile = func(name string) (*os.File, error) {
+		select {
+		case openFiles <- struct{}{}:
+			<-openFiles
+			return os.Open(name)
+		default:
+			return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EMFILE}
+		}
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		openFiles.release()
+	}()
+
+	if _, err := hashFile(path); err != nil {
+		t.Fatalf("hashFile: %v", err)
+	}
+}
+
+func TestOpenWatchedGivesUpWhenOutOfDescriptors(t *testing.T) {
+	backoff := fdRetryBackoff
+	fdRetryBackoff = time.Microsecond
+	calls := 0
+	openFile = func(name string) (*os.File, error) {
+		calls++
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EMFILE}
+	}
+	t.Cleanup(func() {
+		openFile = os.Open
+		fdRetryBackoff = backoff
+	})
+	if _, err := openWatched("/nonexistent"); !isOutOfDescriptors(err) {
+		t.Fatalf("openWatched error = %v, want EMFILE", err)
+	}
+	if calls != fdRetries+1 {
+		t.Errorf("opened %d times, want %d", calls, fdRetries+1)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build windows
+
+package main
+
+import "syscall"
+
+// errSharingViolation is ERROR_SHARING_VIOLATION, returned when another
+// process holds the file open without sharing.
+var errSharingViolation error = syscall.Errno(32)
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"log"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const defaultLogMaxSizeMB = 100
+
+// setupLogging sends log output to log_file, rotated once it reaches
+// log_max_size_mb with log_max_backups old files kept. Without log_file
+// logs stay on stderr. The returned function closes the file.
+func setupLogging(config Config) func() {
+	if config.LogFile == "" {
+		return func() {}
+	}
+	maxSize := config.LogMaxSizeMB
+	if maxSize == 0 {
+		maxSize = defaultLogMaxSizeMB
+	}
+	out := &lumberjack.Logger{
+		Filename:   config.LogFile,
+		MaxSize:    maxSize,
+		MaxBackups: config.LogMaxBackups,
+	}
+	log.SetOutput(out)
+	return func() { out.Close() }
+}
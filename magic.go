@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+)
+
+// magicSignatures maps the names accepted by include_magic to the bytes
+// files of that type start with.
+var magicSignatures = map[string][][]byte{
+	"PDF":  {[]byte("%PDF-")},
+	"PNG":  {[]byte("\x89PNG\r\n\x1a\n")},
+	"JPEG": {[]byte("\xff\xd8\xff")},
+	"GIF":  {[]byte("GIF87a"), []byte("GIF89a")},
+	"ZIP":  {[]byte("PK\x03\x04"), []byte("PK\x05\x06")},
+	"GZIP": {[]byte("\x1f\x8b")},
+	"ELF":  {[]byte("\x7fELF")},
+}
+
+// knownMagic reports whether name can appear in include_magic.
+func knownMagic(name string) bool {
+	_, ok := magicSignatures[strings.ToUpper(name)]
+	return ok
+}
+
+// matchesMagic reports whether the file at path starts with the signature
+// of any of the named types. A file shorter than a signature cannot match
+// it.
+func matchesMagic(path string, names []string) (bool, error) {
+	f, err := openWatched(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	header := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+	header = header[:n]
+
+	for _, name := range names {
+		for _, sig := range magicSignatures[strings.ToUpper(name)] {
+			if bytes.HasPrefix(header, sig) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
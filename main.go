@@ -1,58 +1,314 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
-	"io/ioutil"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/signal"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/template"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/spf13/viper"
 )
 
 type FileData struct {
-	Path string `json:"path"`
-	Size int64  `json:"size"`
+	Seq        uint64    `json:"seq"`
+	Time       time.Time `json:"time"`
+	Path       string    `json:"path"`
+	WatchRoot  string    `json:"watch_root,omitempty"`
+	Size       fileSize  `json:"size"`
+	Key        string    `json:"key,omitempty"`
+	Event      string    `json:"event,omitempty"`
+	Mode       string    `json:"mode,omitempty"`
+	LinkTarget string    `json:"link_target,omitempty"`
+
+	ContentType      string                 `json:"content_type,omitempty"`
+	InnerContentType string                 `json:"inner_content_type,omitempty"`
+	Tags             map[string]string      `json:"tags,omitempty"`
+	Metadata         map[string]interface{} `json:"metadata,omitempty"`
+	Checksum         string                 `json:"checksum,omitempty"`
+	TailChecksum     string                 `json:"tail_checksum,omitempty"`
+	FuzzyHash        string                 `json:"fuzzy_hash,omitempty"`
+
+	Inode     uint64 `json:"inode,omitempty"`
+	Device    uint64 `json:"device,omitempty"`
+	HardLinks uint64 `json:"hard_links,omitempty"`
+
+	Host string `json:"host,omitempty"`
+
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+
+	Sampled   bool `json:"sampled,omitempty"`
+	Truncated bool `json:"truncated,omitempty"`
 }
 
+// eventSeq numbers recorded events in order. It is process-local and
+// restarts from 1 on every run.
+var eventSeq uint64
+
 type Config struct {
-	TargetDirectory  string
-	StorageLocation  string
-	ConcurrencyLevel int
+	TargetDirectory        string            `mapstructure:"target_directory"`
+	TargetDirectories      []string          `mapstructure:"target_directories"`
+	StorageLocation        string            `mapstructure:"storage_location"`
+	ConcurrencyLevel       int               `mapstructure:"concurrency_level"`
+	ExcludeRegex           []string          `mapstructure:"exclude_regex"`
+	MaxOpenFiles           int               `mapstructure:"max_open_files"`
+	LockedFileRetries      int               `mapstructure:"locked_file_retries"`
+	Format                 string            `mapstructure:"format"`
+	RecordEvents           []string          `mapstructure:"record_events"`
+	BatchSize              int               `mapstructure:"batch_size"`
+	FlushInterval          time.Duration     `mapstructure:"flush_interval"`
+	KeyTemplate            string            `mapstructure:"key_template"`
+	InspectCompressed      bool              `mapstructure:"inspect_compressed"`
+	StatsInterval          time.Duration     `mapstructure:"stats_interval"`
+	AllowedOwners          []int             `mapstructure:"allowed_owners"`
+	Debug                  bool              `mapstructure:"debug"`
+	Recursive              bool              `mapstructure:"recursive"`
+	MaxDepth               int               `mapstructure:"max_depth"`
+	DebounceInterval       time.Duration     `mapstructure:"debounce_interval"`
+	DebounceByExt          map[string]string `mapstructure:"debounce_by_ext"`
+	Sinks                  []SinkConfig      `mapstructure:"sinks"`
+	WatchMode              string            `mapstructure:"watch_mode"`
+	PollInterval           time.Duration     `mapstructure:"poll_interval"`
+	PollHash               bool              `mapstructure:"poll_hash"`
+	SettleDelay            time.Duration     `mapstructure:"settle_delay"`
+	AggregateInterval      time.Duration     `mapstructure:"aggregate_interval"`
+	HashBufferSize         int               `mapstructure:"hash_buffer_size"`
+	RecordSpecialFiles     bool              `mapstructure:"record_special_files"`
+	ExecCommand            []string          `mapstructure:"exec_command"`
+	ExecTimeout            time.Duration     `mapstructure:"exec_timeout"`
+	ExecConcurrency        int               `mapstructure:"exec_concurrency"`
+	Checksum               bool              `mapstructure:"checksum"`
+	IncrementalChecksum    bool              `mapstructure:"incremental_checksum"`
+	LogFile                string            `mapstructure:"log_file"`
+	LogMaxSizeMB           int               `mapstructure:"log_max_size_mb"`
+	LogMaxBackups          int               `mapstructure:"log_max_backups"`
+	IncludeMagic           []string          `mapstructure:"include_magic"`
+	RetryQueue             string            `mapstructure:"retry_queue"`
+	CaseInsensitive        bool              `mapstructure:"case_insensitive"`
+	WarmupDelay            time.Duration     `mapstructure:"warmup_delay"`
+	SizeAsString           bool              `mapstructure:"size_as_string"`
+	ControlSocket          string            `mapstructure:"control_socket"`
+	PauseBuffer            bool              `mapstructure:"pause_buffer"`
+	ExtractMediaMetadata   bool              `mapstructure:"extract_media_metadata"`
+	MinFreeDiskBytes       int64             `mapstructure:"min_free_disk_bytes"`
+	MaxHeldRecords         int               `mapstructure:"max_held_records"`
+	StorageOpenFiles       int               `mapstructure:"storage_open_files"`
+	Filter                 string            `mapstructure:"filter"`
+	InstanceID             string            `mapstructure:"instance_id"`
+	CompletionMarkerSuffix string            `mapstructure:"completion_marker_suffix"`
+	CompletionMarkerDelete bool              `mapstructure:"completion_marker_delete"`
+	ScanOnStart            bool              `mapstructure:"scan_on_start"`
+	TrackMoves             bool              `mapstructure:"track_moves"`
+	MoveWindow             time.Duration     `mapstructure:"move_window"`
+	SampleRate             float64           `mapstructure:"sample_rate"`
+	SamplePerPath          bool              `mapstructure:"sample_per_path"`
+	RemoteProvider         string            `mapstructure:"remote_provider"`
+	RemoteEndpoint         string            `mapstructure:"remote_endpoint"`
+	RemoteKey              string            `mapstructure:"remote_key"`
+	RemoteFormat           string            `mapstructure:"remote_format"`
+	RemoteRefresh          time.Duration     `mapstructure:"remote_refresh"`
+	MaxRecordBytes         int               `mapstructure:"max_record_bytes"`
+	WatchStrategy          string            `mapstructure:"watch_strategy"`
+	HybridPattern          string            `mapstructure:"hybrid_pattern"`
+	HybridSweepInterval    time.Duration     `mapstructure:"hybrid_sweep_interval"`
+	FollowSymlinks         bool              `mapstructure:"follow_symlinks"`
+	DedupeByPath           bool              `mapstructure:"dedupe_by_path"`
+	DedupeTTL              time.Duration     `mapstructure:"dedupe_ttl"`
+	ServiceMode            bool              `mapstructure:"service_mode"`
+	ServiceName            string            `mapstructure:"service_name"`
+	FuzzyHashing           bool              `mapstructure:"fuzzy_hashing"`
+	HardLinks              bool              `mapstructure:"hard_links"`
+	RunDuration            time.Duration     `mapstructure:"run_duration"`
+	SkipOpenFiles          bool              `mapstructure:"skip_open_files"`
+	Routes                 []RouteConfig     `mapstructure:"routes"`
+	DefaultSinks           []string          `mapstructure:"default_sinks"`
+	IncludePatterns        []string          `mapstructure:"include_patterns"`
+	ExcludePatterns        []string          `mapstructure:"exclude_patterns"`
 }
 
 func main() {
 	// Setup command line flags
 	configPath := flag.String("config", "configuration.yaml", "path to config file")
+	check := flag.Bool("check", false, "validate the config and exit")
+	replay := flag.Bool("replay-storage", false, "send the recorded events to the configured sinks and exit")
 	flag.Parse()
 
 	// Load configuration
 	var config Config
 	viper.SetConfigFile(*configPath)
+	setConfigDefaults(viper.GetViper())
 	if err := viper.ReadInConfig(); err != nil {
 		log.Fatalf("Error reading config file: %v", err)
 	}
 	if err := viper.Unmarshal(&config); err != nil {
 		log.Fatalf("Error parsing config file: %v", err)
 	}
+	var remote *viper.Viper
+	if config.RemoteProvider != "" {
+		var err error
+		if remote, err = loadRemoteConfig(config); err != nil {
+			log.Fatalf("Error reading remote config: %v", err)
+		}
+		if err := viper.Unmarshal(&config); err != nil {
+			log.Fatalf("Error parsing remote config: %v", err)
+		}
+	}
+
+	debugLogging = config.Debug
+	foldCase = config.CaseInsensitive
+	lockedFileRetries = config.LockedFileRetries
+	sizeAsString = config.SizeAsString
+
+	// Validate configuration
+	if err := validateConfig(config); err != nil {
+		printConfigError(err)
+		os.Exit(1)
+	}
+	if *check {
+		if err := probeSinks(config.Sinks); err != nil {
+			printConfigError(err)
+			os.Exit(1)
+		}
+		fmt.Println("Configuration OK")
+		return
+	}
+	closeLog := setupLogging(config)
+	defer closeLog()
 
-	// Create a watcher
-	watcher, err := fsnotify.NewWatcher()
+	sinks, err := newSinks(config.Sinks)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("Invalid sinks: %v", err)
+	}
+	routes, err := newRouter(config.Routes, config.DefaultSinks, sinks)
+	if err != nil {
+		log.Fatalf("Invalid routes: %v", err)
+	}
+	if *replay {
+		if len(sinks) == 0 {
+			log.Fatal("No sinks configured to replay to")
+		}
+		if err := replayStorage(config, sinks, routes); err != nil {
+			log.Fatalf("Replay failed: %v", err)
+		}
+		return
 	}
-	defer watcher.Close()
 
-	// Add the target directory to the watcher
-	err = watcher.Add(config.TargetDirectory)
+	keyTmpl, err := parseKeyTemplate(config.KeyTemplate)
+	if err != nil {
+		log.Fatalf("Invalid key_template: %v", err)
+	}
+
+	debounceByExt, err := parseDebounceByExt(config.DebounceByExt)
+	if err != nil {
+		log.Fatalf("Invalid debounce_by_ext: %v", err)
+	}
+
+	runner, err := newCommandRunner(config)
+	if err != nil {
+		log.Fatalf("Invalid exec_command: %v", err)
+	}
+
+	setHashBufferSize(config.HashBufferSize)
+
+	// Bound the number of files open at once across all workers
+	maxOpenFiles := config.MaxOpenFiles
+	if maxOpenFiles == 0 {
+		maxOpenFiles = defaultMaxOpenFiles()
+	}
+	openFiles := newSemaphore(maxOpenFiles)
+	rec := newRecorder(config, openFiles)
+
+	// Retry failed sink deliveries in the background, across restarts
+	var retry *retryQueue
+	if config.RetryQueue != "" && len(sinks) > 0 {
+		retry, err = openRetryQueue(config.RetryQueue, sinks)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Watch the target directories
+	roots, err := watchRoots(config)
 	if err != nil {
 		log.Fatal(err)
 	}
+	roots = pruneNestedRoots(roots, config.Recursive, config.MaxDepth)
+	var source watchSource
+	var notify *eventSource
+
+	// Compile exclusions, sampling, the filter and routes up front so bad
+	// settings fail fast
+	live, err := newLiveSettings(config, sinks, roots)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 
-	// Channel for file paths to be processed
-	fileChan := make(chan string, config.ConcurrencyLevel)
+	if config.WatchMode == watchModePoll {
+		source = newPoller(roots, config, openFiles)
+	} else if config.WatchStrategy == watchStrategyHybrid {
+		source, err = newHybridSource(roots, config)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		notify, err = newEventSource(roots)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if config.Recursive {
+			for _, root := range roots {
+				watchTree(notify, root, root, config.MaxDepth, live.globs)
+			}
+		}
+		source = notify
+	}
+	proc := &processor{
+		config:    config,
+		roots:     roots,
+		rec:       rec,
+		retry:     retry,
+		runner:    runner,
+		checksums: newChecksummer(config.IncrementalChecksum),
+		paths:     newPathLocks(),
+		openFiles: openFiles,
+		keyTmpl:   keyTmpl,
+	}
+	proc.live.Store(live)
+	if remote != nil && config.RemoteRefresh > 0 {
+		go watchRemoteConfig(remote, *configPath, config.RemoteRefresh, func(config Config) error {
+			return proc.reload(config, sinks)
+		})
+	}
+	if config.DedupeByPath {
+		proc.dedupe = newPathIndex(config.DedupeTTL)
+		defer proc.dedupe.close()
+	}
+	var moves *moveTracker
+	if config.TrackMoves {
+		moves = newMoveTracker(config.MoveWindow, func(path string, id fileID) {
+			proc.record(FileData{
+				Path:      path,
+				WatchRoot: rootFor(roots, path),
+				Event:     eventRemove,
+				Inode:     id.inode,
+				Device:    id.device,
+			})
+		})
+		proc.moves = moves
+	}
+
+	recordEvents := eventSet(config.RecordEvents)
+
+	// Channel for file events to be processed
+	fileChan := make(chan fileEvent, config.ConcurrencyLevel)
 	var wg sync.WaitGroup
 
 	// Start worker goroutines
@@ -60,38 +316,285 @@ func main() {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for path := range fileChan {
-				processFile(path, config.StorageLocation)
+			for ev := range fileChan {
+				proc.processFile(ev)
 			}
 		}()
 	}
 
-	// Monitor the directory
+	// Hold back files another process is still writing
+	if config.SkipOpenFiles {
+		proc.openWait = newOpenWaiter(func(ev fileEvent) {
+			fileChan <- ev
+		})
+	}
+
+	// Coalesce bursts of events per path, then let new files settle,
+	// before they reach the workers
+	settle := newSettler(config.SettleDelay, func(ev fileEvent) {
+		fileChan <- ev
+	})
+	debounce := newDebouncer(config.DebounceInterval, debounceByExt, settle.add)
+
+	// Discard events until the warm-up delay has passed
+	warmupUntil := time.Now().Add(config.WarmupDelay)
+	if config.WarmupDelay > 0 {
+		log.Printf("Warming up, discarding events for %v", config.WarmupDelay)
+		time.AfterFunc(config.WarmupDelay, func() {
+			log.Printf("Warm-up finished, recording events")
+		})
+	}
+
+	// Let operators pause and resume recording over the control socket
+	pause := newPauser(config.PauseBuffer)
+	proc.pause = pause
+	var control *controlServer
+	if config.ControlSocket != "" {
+		control, err = startControlServer(config.ControlSocket, pause, rec, fileChan, debounce.add, func(fileData FileData) {
+			proc.record(fileData)
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// The startup scan feeds the workers' queue, so the queue stays open
+	// until it has stopped
+	var scanning sync.WaitGroup
+	if config.ScanOnStart {
+		scanning.Add(1)
+	}
+
+	// Monitor the directory. The workers stop once this loop ends, the
+	// startup scan has stopped and the events still held back have been
+	// queued.
 	go func() {
-		for {
-			select {
-			case event, ok := <-watcher.Events:
-				if !ok {
+		defer close(fileChan)
+		defer scanning.Wait()
+		if proc.openWait != nil {
+			defer proc.openWait.close()
+		}
+		defer settle.wait()
+		defer debounce.flush()
+		if moves != nil {
+			defer moves.flush()
+		}
+		queue := func(ev fileEvent) {
+			if pause.admit(ev) {
+				debounce.add(ev)
+			}
+		}
+		var watched func(string) bool
+		if notify != nil {
+			watched = notify.watching
+		}
+		source.run(func(event fsnotify.Event) {
+			live := proc.settings()
+			if matchesAny(live.exclude, event.Name) || live.globs.skipEvent(event, watched) {
+				return
+			}
+			if notify != nil && config.Recursive {
+				trackDirectory(notify, roots, config.MaxDepth, live.globs, event)
+			}
+			if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				proc.checksums.forget(event.Name)
+			}
+			if time.Now().Before(warmupUntil) {
+				return
+			}
+			event = platformEvent(event)
+			if moves != nil {
+				if ev, ok := moves.observe(event); ok {
+					queue(ev)
 					return
 				}
-				if event.Op&fsnotify.Create == fsnotify.Create || event.Op&fsnotify.Write == fsnotify.Write {
-					fileChan <- event.Name
-				}
-			case err, ok := <-watcher.Errors:
-				if !ok {
-					return
+			}
+			if config.CompletionMarkerSuffix != "" {
+				if ev, ok := markerEvent(event, config.CompletionMarkerSuffix); ok {
+					queue(ev)
 				}
-				log.Println("error:", err)
+			} else if name := recordedEvent(event.Op, recordEvents); name != "" {
+				queue(fileEvent{Path: event.Name, Event: name})
 			}
-		}
+		})
 	}()
 
-	// Wait for the goroutines to finish
-	wg.Wait()
-	close(fileChan)
+	// Record the files already present, alongside live events
+	var scan *startupScan
+	if config.ScanOnStart {
+		scan = newStartupScan(roots, config, live.exclude, live.globs, fileChan)
+		go func() {
+			defer scanning.Done()
+			scan.run()
+		}()
+	}
+
+	// Shut down on interrupt or a service stop: closing the source ends
+	// the event loop
+	var shutdownOnce sync.Once
+	var service *serviceNotifier
+	shutdown := func(reason string) {
+		shutdownOnce.Do(func() {
+			log.Printf("%s, shutting down", reason)
+			service.stopping()
+			if control != nil {
+				control.close()
+			}
+			if scan != nil {
+				scan.cancel()
+			}
+			source.close()
+		})
+	}
+	service = startService(config, shutdown)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		shutdown(fmt.Sprintf("Received %v", sig))
+	}()
+	started := time.Now()
+	if config.RunDuration > 0 {
+		time.AfterFunc(config.RunDuration, func() {
+			shutdown(fmt.Sprintf("Run duration of %v elapsed", config.RunDuration))
+		})
+	}
+
+	// Snapshot directory totals periodically
+	var agg *aggregator
+	if config.AggregateInterval > 0 {
+		agg = startAggregator(roots, config, rec)
+	}
+
+	workersDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(workersDone)
+	}()
+
+	// Log throughput periodically until the workers have finished
+	var statsTick <-chan time.Time
+	if config.StatsInterval > 0 {
+		ticker := time.NewTicker(config.StatsInterval)
+		defer ticker.Stop()
+		statsTick = ticker.C
+	}
+	stats := newStatsLogger(fileChan, config.ConcurrencyLevel)
+	service.ready()
+	for running := true; running; {
+		select {
+		case now := <-statsTick:
+			stats.tick(now)
+		case <-workersDone:
+			running = false
+		}
+	}
+
+	// Write what is still buffered
+	if agg != nil {
+		agg.stop()
+	}
+	if retry != nil {
+		retry.close()
+	}
+	rec.close()
+	log.Printf("Stopped after %v: %d events recorded, %d exec failures",
+		time.Since(started).Round(time.Second), atomic.LoadUint64(&eventSeq), atomic.LoadUint64(&execFailures))
+	service.stopped()
+}
+
+// setConfigDefaults sets the defaults of the settings whose zero value is
+// not their default, on the global viper at startup and on the fresh one
+// built for each remote refresh.
+func setConfigDefaults(v *viper.Viper) {
+	v.SetDefault("case_insensitive", defaultCaseInsensitive())
+	v.SetDefault("sample_rate", 1.0)
+	v.SetDefault("follow_symlinks", true)
+	v.SetDefault("service_name", "FileEvents")
+	if host, err := os.Hostname(); err == nil {
+		v.SetDefault("instance_id", host)
+	}
+}
+
+// processor turns queued file events into records.
+type processor struct {
+	config    Config
+	roots     []string
+	rec       *recorder
+	retry     *retryQueue
+	runner    *commandRunner
+	checksums *checksummer
+	paths     *pathLocks
+	openFiles semaphore
+	keyTmpl   *template.Template
+	moves     *moveTracker
+	dedupe    *pathIndex
+	openWait  *openWaiter
+	pause     *pauser
+	live      atomic.Value // *liveSettings
 }
 
-func processFile(path string, storageLocation string) {
+// settings returns the live settings in effect.
+func (p *processor) settings() *liveSettings {
+	return p.live.Load().(*liveSettings)
+}
+
+// reload validates config and puts its live settings into effect.
+func (p *processor) reload(config Config, sinks []Sink) error {
+	if err := validateConfig(config); err != nil {
+		return err
+	}
+	live, err := newLiveSettings(config, sinks, p.roots)
+	if err != nil {
+		return err
+	}
+	p.live.Store(live)
+	return nil
+}
+
+func (p *processor) processFile(ev fileEvent) {
+	path := ev.Path
+	if isSidecar(path) {
+		debugf("Skipping %s: sidecar", path)
+		return
+	}
+	if sample := p.settings().sample; sample != nil && !sample.keep(path) {
+		return
+	}
+	unlock := p.paths.lock(path)
+	defer unlock()
+	// The marker goes only once the data file has actually been recorded,
+	// not when it was filtered out, deduplicated, held back by a pause or
+	// deferred
+	var recorded bool
+	if ev.Marker != "" && p.config.CompletionMarkerDelete {
+		defer func() {
+			if recorded {
+				removeMarker(ev)
+			}
+		}()
+	}
+
+	// Record symlinks themselves, with their target, unless following them
+	if !p.config.FollowSymlinks {
+		if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			if !p.ownerAllowed(info) {
+				debugf("Skipping %s: owner not in allowed_owners", path)
+				return
+			}
+			fileData := FileData{
+				Path:       path,
+				WatchRoot:  rootFor(p.roots, path),
+				Size:       fileSize(info.Size()),
+				Event:      ev.Event,
+				LinkTarget: linkTarget(path),
+			}
+			fileData.Inode, fileData.Device = fileIdentity(info)
+			recorded = p.record(fileData)
+			return
+		}
+	}
+
 	// Read file content
 	info, err := os.Stat(path)
 	if err != nil {
@@ -99,36 +602,209 @@ func processFile(path string, storageLocation string) {
 		return
 	}
 
-	// Create file data
-	fileData := FileData{
-		Path: path,
-		Size: info.Size(),
+	if !p.ownerAllowed(info) {
+		debugf("Skipping %s: owner not in allowed_owners", path)
+		return
 	}
 
-	// Read existing data
-	var fileDataList []FileData
-	if _, err := os.Stat(storageLocation); err == nil {
-		data, err := ioutil.ReadFile(storageLocation)
+	if p.openWait != nil && info.Mode().IsRegular() {
+		open, err := openForWriting(path)
 		if err != nil {
-			log.Printf("Failed to read storage file: %v", err)
+			log.Printf("Failed to check whether %s is open: %v", path, err)
+		} else if open {
+			debugf("Deferring %s: open for writing by another process", path)
+			p.openWait.wait(ev)
 			return
 		}
-		if err := json.Unmarshal(data, &fileDataList); err != nil {
-			log.Printf("Failed to unmarshal storage file: %v", err)
+	}
+
+	// Never open pipes, devices or sockets: reading a FIFO blocks forever
+	if !info.Mode().IsRegular() && !info.IsDir() {
+		if !p.config.RecordSpecialFiles {
+			debugf("Skipping special file %s", path)
 			return
 		}
+		recorded = p.record(FileData{
+			Path:      path,
+			WatchRoot: rootFor(p.roots, path),
+			Event:     eventSpecial,
+			Mode:      info.Mode().String(),
+		})
+		return
 	}
 
-	// Update file data
-	fileDataList = append(fileDataList, fileData)
+	if len(p.config.IncludeMagic) > 0 {
+		if info.IsDir() {
+			return
+		}
+		p.openFiles.acquire()
+		ok, err := matchesMagic(path, p.config.IncludeMagic)
+		p.openFiles.release()
+		if err != nil {
+			log.Printf("Failed to read header of %s: %v", path, err)
+			return
+		}
+		if !ok {
+			debugf("Skipping %s: content does not match include_magic", path)
+			return
+		}
+	}
 
-	// Write updated data
-	data, err := json.MarshalIndent(fileDataList, "", "  ")
+	// Create file data
+	fileData := FileData{
+		Path:      path,
+		WatchRoot: rootFor(p.roots, path),
+		Size:      fileSize(info.Size()),
+		Event:     ev.Event,
+	}
+	if ev.Event == eventMove {
+		fileData.From, fileData.To = ev.From, path
+	}
+	if ev.Event == eventChmod {
+		fileData.Mode = fmt.Sprintf("%04o", info.Mode().Perm())
+	}
+	fileData.Inode, fileData.Device = fileIdentity(info)
+	if p.config.HardLinks {
+		fileData.HardLinks = fileLinks(info)
+		if fileData.HardLinks > 1 {
+			debugf("%s has %d hard links", path, fileData.HardLinks)
+		}
+	}
+	if (p.config.InspectCompressed || p.config.ExtractMediaMetadata) && info.Mode().IsRegular() {
+		p.openFiles.acquire()
+		contentType, err := detectContentType(path)
+		if err != nil {
+			log.Printf("Failed to detect content type of %s: %v", path, err)
+		} else {
+			fileData.ContentType = contentType
+			if p.config.InspectCompressed {
+				fileData.InnerContentType = detectInnerContentType(path, contentType)
+			}
+			if p.config.ExtractMediaMetadata {
+				metadata, err := extractMediaMetadata(path, contentType)
+				if err != nil {
+					debugf("No media metadata for %s: %v", path, err)
+				}
+				fileData.Metadata = metadata
+			}
+		}
+		p.openFiles.release()
+	}
+	// The fuzzy hash shares the checksum's read of the file when both are on
+	var fuzzy *fuzzyHasher
+	if p.config.FuzzyHashing && info.Mode().IsRegular() && info.Size() >= fuzzyMinSize {
+		fuzzy = newFuzzyHasher(info.Size())
+	}
+	if (p.config.Checksum || p.config.IncrementalChecksum) && info.Mode().IsRegular() {
+		var also io.Writer
+		if fuzzy != nil {
+			also = fuzzy
+		}
+		p.openFiles.acquire()
+		sum, tail, err := p.checksums.checksum(path, info, also)
+		p.openFiles.release()
+		if err != nil {
+			log.Printf("Failed to checksum %s: %v", path, err)
+		} else if fuzzy != nil {
+			fileData.FuzzyHash = fuzzy.sum()
+		}
+		fileData.Checksum, fileData.TailChecksum = sum, tail
+	} else if fuzzy != nil {
+		p.openFiles.acquire()
+		sum, err := fuzzyHashFile(path, info.Size())
+		p.openFiles.release()
+		if err != nil {
+			log.Printf("Failed to fuzzy hash %s: %v", path, err)
+		}
+		fileData.FuzzyHash = sum
+	}
+	p.openFiles.acquire()
+	tags, err := readSidecarTags(path)
+	p.openFiles.release()
 	if err != nil {
-		log.Printf("Failed to marshal data: %v", err)
-		return
+		debugf("Ignoring sidecar for %s: %v", path, err)
+	}
+	fileData.Tags = tags
+	if p.keyTmpl != nil {
+		key, err := renderKey(p.keyTmpl, fileData.WatchRoot, path, time.Now())
+		if err != nil {
+			log.Printf("Failed to render key for %s: %v", path, err)
+			return
+		}
+		fileData.Key = key
+	}
+	recorded = p.record(fileData)
+}
+
+// record numbers fileData and hands it to storage, the sinks and
+// exec_command. It reports whether fileData was recorded rather than held
+// back by a pause or skipped by the filter or dedupe_by_path.
+func (p *processor) record(fileData FileData) bool {
+	if p.pause != nil && !p.pause.admitRecord(fileData) {
+		debugf("Holding back %s: paused", fileData.Path)
+		return false
+	}
+	live := p.settings()
+	fileData.Host = p.config.InstanceID
+	fileData.Sampled = live.sample != nil
+	if live.filter != nil {
+		keep, err := live.filter.match(fileData)
+		if err != nil {
+			log.Printf("Failed to evaluate filter for %s: %v", fileData.Path, err)
+			return false
+		}
+		if !keep {
+			debugf("Skipping %s: filter did not match", fileData.Path)
+			return false
+		}
+	}
+	if p.dedupe != nil && !p.dedupe.first(fileData.Path, time.Now()) {
+		debugf("Skipping %s: already recorded", fileData.Path)
+		return false
+	}
+	if p.moves != nil && fileData.Event != eventRemove {
+		p.moves.remember(fileData.Path, fileID{fileData.Inode, fileData.Device})
 	}
-	if err := ioutil.WriteFile(storageLocation, data, 0644); err != nil {
-		log.Printf("Failed to write storage file: %v", err)
+	fileData.Seq = atomic.AddUint64(&eventSeq, 1)
+	fileData.Time = time.Now()
+	if p.config.MaxRecordBytes > 0 {
+		fileData = fitRecord(fileData, p.config.MaxRecordBytes)
+	}
+	p.rec.add(fileData)
+	dispatch(live.routes.sinksFor(fileData), fileData, p.retry)
+	if p.runner != nil {
+		p.runner.run(fileData)
+	}
+	return true
+}
+
+// trackDirectory keeps recursive watches in step with the tree: new
+// directories within max_depth are watched, removed ones forgotten.
+func trackDirectory(source *eventSource, roots []string, maxDepth int, globs *globFilter, event fsnotify.Event) {
+	switch {
+	case event.Op&fsnotify.Create == fsnotify.Create:
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			watchTree(source, rootFor(roots, event.Name), event.Name, maxDepth, globs)
+		}
+	case event.Op&(fsnotify.Remove|fsnotify.Rename) != 0:
+		source.forget(event.Name)
+	}
+}
+
+// ownerAllowed reports whether info is owned by one of allowed_owners. An
+// empty list, or a platform without file owners, allows everything.
+func (p *processor) ownerAllowed(info os.FileInfo) bool {
+	if len(p.config.AllowedOwners) == 0 {
+		return true
+	}
+	uid, ok := fileOwner(info)
+	if !ok {
+		return true
+	}
+	for _, allowed := range p.config.AllowedOwners {
+		if uid == allowed {
+			return true
+		}
 	}
+	return false
 }
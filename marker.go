@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// eventComplete marks a data file recorded because its completion marker
+// appeared; it is not selectable in record_events.
+const eventComplete = "complete"
+
+// markerEvent maps an event in completion-marker mode. Creating
+// "<data><suffix>" queues the data file; every other event, including
+// those of the data file itself, is ignored.
+func markerEvent(event fsnotify.Event, suffix string) (fileEvent, bool) {
+	if event.Op&fsnotify.Create == 0 || !strings.HasSuffix(event.Name, suffix) {
+		return fileEvent{}, false
+	}
+	data := strings.TrimSuffix(event.Name, suffix)
+	if data == "" || strings.HasSuffix(data, string(os.PathSeparator)) {
+		return fileEvent{}, false
+	}
+	return fileEvent{Path: data, Event: eventComplete, Marker: event.Name}, true
+}
+
+// removeMarker deletes the completion marker of ev once its data file has
+// been processed. The marker is kept when the data file is missing, so the
+// signal is not lost.
+func removeMarker(ev fileEvent) {
+	if _, err := os.Stat(ev.Path); err != nil {
+		log.Printf("Keeping marker %s: %v", ev.Marker, err)
+		return
+	}
+	if err := os.Remove(ev.Marker); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove marker %s: %v", ev.Marker, err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// With completion_marker_delete the marker goes only once its data file
+// has been recorded; a file sampled out, filtered out, deduplicated,
+// held by a pause or gone keeps it.
+func TestCompletionMarkerDeletedOnlyWhenRecorded(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		config   Config
+		before   func(p *processor, data string)
+		missing  bool
+		recorded bool
+	}{
+		{name: "recorded", recorded: true},
+		{name: "sampled out", config: Config{SampleRate: 1e-9, SamplePerPath: true}},
+		{name: "filtered out", config: Config{Filter: `size > 1 * MB`}},
+		{name: "already recorded", before: func(p *processor, data string) {
+			p.dedupe = newPathIndex(0)
+			p.dedupe.first(data, time.Now())
+		}},
+		{name: "paused", before: func(p *processor, data string) {
+			p.pause = newPauser(false)
+			p.pause.pause()
+		}},
+		{name: "data file missing", missing: true},
+	} {
+		dir := t.TempDir()
+		watched := filepath.Join(dir, "watched")
+		if err := os.Mkdir(watched, 0755); err != nil {
+			t.Fatal(err)
+		}
+		data := filepath.Join(watched, "data.csv")
+		marker := data + ".done"
+		if !tt.missing {
+			if err := ioutil.WriteFile(data, []byte("a,b\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := ioutil.WriteFile(marker, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		config := tt.config
+		config.StorageLocation = filepath.Join(dir, "events.ndjson")
+		config.Format = formatNDJSON
+		config.CompletionMarkerSuffix = ".done"
+		config.CompletionMarkerDelete = true
+		p := newTestProcessor(t, config, []string{watched})
+		if tt.before != nil {
+			tt.before(p, data)
+		}
+		ev, ok := markerEvent(fsnotify.Event{Name: marker, Op: fsnotify.Create}, config.CompletionMarkerSuffix)
+		if !ok {
+			t.Fatalf("%s: marker not recognised", tt.name)
+		}
+		p.processFile(ev)
+		p.rec.close()
+
+		records, err := readRecords(config.StorageLocation, formatNDJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(records) == 1; got != tt.recorded {
+			t.Errorf("%s: recorded %+v", tt.name, records)
+		}
+		_, err = os.Stat(marker)
+		if kept := err == nil; kept == tt.recorded {
+			t.Errorf("%s: marker kept = %v, want %v", tt.name, kept, !tt.recorded)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+// PDF pages are counted without reading the whole file. The trailer at the
+// end points through the cross-reference table to the catalog and from
+// there to the root of the page tree, whose /Count is the page count; each
+// hop reads a small window at a known offset. PDFs whose cross-reference
+// data is compressed fall back to scanning a window at either end.
+const (
+	pdfTrailerScan = 64 << 10 // read from the end for startxref and /Root
+	pdfObjectScan  = 4 << 10  // read at an object's offset
+	pdfWindowScan  = 1 << 20  // read from each end when the lookup fails
+	pdfMaxXrefs    = 32       // incremental updates followed through /Prev
+	pdfMaxSections = 1024     // subsections walked in one xref table
+	pdfXrefEntry   = 20       // bytes per cross-reference entry
+)
+
+var (
+	pdfPageObject = regexp.MustCompile(`/Type\s*/Page\b`)
+	pdfPageCount  = regexp.MustCompile(`/Type\s*/Pages\b[^>]*?/Count\s+(\d+)|/Count\s+(\d+)[^>]*?/Type\s*/Pages\b`)
+
+	pdfStartXref  = regexp.MustCompile(`startxref\s+(\d+)`)
+	pdfRootRef    = regexp.MustCompile(`/Root\s+(\d+)\s+\d+\s+R`)
+	pdfPagesRef   = regexp.MustCompile(`/Pages\s+(\d+)\s+\d+\s+R`)
+	pdfPrevRef    = regexp.MustCompile(`/Prev\s+(\d+)`)
+	pdfCount      = regexp.MustCompile(`/Count\s+(\d+)`)
+	pdfSubsection = regexp.MustCompile(`^(\d+)[ \t]+(\d+)[ \t]*(?:\r\n|\r|\n)`)
+	pdfEntry      = regexp.MustCompile(`^(\d{10}) \d{5} ([nf])`)
+)
+
+// extractMediaMetadata returns width and height for images, or the page
+// count for PDFs, based on contentType. Other types, and files too short or
+// corrupt to parse, yield nil so the rest of the record is unaffected.
+func extractMediaMetadata(path, contentType string) (map[string]interface{}, error) {
+	switch contentType {
+	case "image/png", "image/jpeg", "image/gif":
+		f, err := openWatched(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		cfg, _, err := image.DecodeConfig(f)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"width": cfg.Width, "height": cfg.Height}, nil
+	case "application/pdf":
+		f, err := openWatched(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		if pages := pdfPageTotal(f, info.Size()); pages > 0 {
+			return map[string]interface{}{"pages": pages}, nil
+		}
+	}
+	return nil, nil
+}
+
+// pdfPageTotal returns the page count of the PDF of size bytes in r, or 0
+// when it cannot be found.
+func pdfPageTotal(r io.ReaderAt, size int64) int {
+	if pages := pdfTreeCount(r, size); pages > 0 {
+		return pages
+	}
+	if size <= 2*pdfWindowScan {
+		return pdfPages(readWindow(r, 0, size))
+	}
+	best := pdfLargestCount(readWindow(r, 0, pdfWindowScan))
+	if n := pdfLargestCount(readWindow(r, size-pdfWindowScan, pdfWindowScan)); n > best {
+		best = n
+	}
+	return best
+}
+
+// pdfTreeCount follows the trailer to the root of the page tree and
+// returns its /Count, or 0 when any step fails.
+func pdfTreeCount(r io.ReaderAt, size int64) int {
+	trailer := readWindow(r, size-pdfTrailerScan, pdfTrailerScan)
+	xref, ok := lastNumber(pdfStartXref, trailer)
+	if !ok {
+		return 0
+	}
+	root, ok := lastNumber(pdfRootRef, trailer)
+	if !ok {
+		return 0
+	}
+	catalog, ok := pdfObject(r, xref, root)
+	if !ok {
+		return 0
+	}
+	pages, ok := lastNumber(pdfPagesRef, catalog)
+	if !ok {
+		return 0
+	}
+	tree, ok := pdfObject(r, xref, pages)
+	if !ok {
+		return 0
+	}
+	count, _ := lastNumber(pdfCount, tree)
+	return int(count)
+}
+
+// pdfObject returns the start of object num, up to its endobj, looking
+// its offset up in the cross-reference table at xref and the tables of
+// earlier revisions.
+func pdfObject(r io.ReaderAt, xref, num int64) ([]byte, bool) {
+	for hop := 0; hop < pdfMaxXrefs; hop++ {
+		offset, found, prev, ok := pdfXrefLookup(r, xref, num)
+		if found {
+			data := readWindow(r, offset, pdfObjectScan)
+			header := []byte(strconv.FormatInt(num, 10) + " ")
+			if !bytes.HasPrefix(data, header) {
+				return nil, false
+			}
+			if end := bytes.Index(data, []byte("endobj")); end >= 0 {
+				data = data[:end]
+			}
+			return data, true
+		}
+		if !ok {
+			return nil, false
+		}
+		xref = prev
+	}
+	return nil, false
+}
+
+// pdfXrefLookup finds object num in the classic cross-reference table at
+// xref. When the table has no entry for it, ok reports whether the
+// trailer names an earlier table to try, at prev.
+func pdfXrefLookup(r io.ReaderAt, xref, num int64) (offset int64, found bool, prev int64, ok bool) {
+	head := readWindow(r, xref, 64)
+	if !bytes.HasPrefix(head, []byte("xref")) {
+		return 0, false, 0, false
+	}
+	pos := xref + int64(len("xref"))
+	for section := 0; section < pdfMaxSections; section++ {
+		line := readWindow(r, pos, 64)
+		skipped := len(line) - len(bytes.TrimLeft(line, " \t\r\n"))
+		line = line[skipped:]
+		pos += int64(skipped)
+		if bytes.HasPrefix(line, []byte("trailer")) {
+			prev, ok := lastNumber(pdfPrevRef, readWindow(r, pos, pdfObjectScan))
+			return 0, false, prev, ok
+		}
+		m := pdfSubsection.FindSubmatch(line)
+		if m == nil {
+			return 0, false, 0, false
+		}
+		start, _ := strconv.ParseInt(string(m[1]), 10, 64)
+		count, _ := strconv.ParseInt(string(m[2]), 10, 64)
+		entries := pos + int64(len(m[0]))
+		if num >= start && num < start+count {
+			entry := pdfEntry.FindSubmatch(readWindow(r, entries+(num-start)*pdfXrefEntry, pdfXrefEntry))
+			if entry == nil || string(entry[2]) != "n" {
+				return 0, false, 0, false
+			}
+			offset, _ := strconv.ParseInt(string(entry[1]), 10, 64)
+			return offset, true, 0, false
+		}
+		pos = entries + count*pdfXrefEntry
+	}
+	return 0, false, 0, false
+}
+
+// readWindow returns up to n bytes of r from offset, which is clamped to
+// the start of the file.
+func readWindow(r io.ReaderAt, offset, n int64) []byte {
+	if offset < 0 {
+		n += offset
+		offset = 0
+	}
+	if n <= 0 {
+		return nil
+	}
+	buf := make([]byte, n)
+	read, _ := r.ReadAt(buf, offset)
+	return buf[:read]
+}
+
+// lastNumber returns the number captured by the last match of re in data.
+func lastNumber(re *regexp.Regexp, data []byte) (int64, bool) {
+	matches := re.FindAllSubmatch(data, -1)
+	if len(matches) == 0 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(string(matches[len(matches)-1][1]), 10, 64)
+	return n, err == nil
+}
+
+// pdfPages estimates the page count of a PDF from its uncompressed objects:
+// the /Count of the largest page tree node, or failing that the number of
+// page objects. Pages kept only in compressed object streams are not seen,
+// in which case it returns 0.
+func pdfPages(data []byte) int {
+	if best := pdfLargestCount(data); best > 0 {
+		return best
+	}
+	return len(pdfPageObject.FindAllIndex(data, -1))
+}
+
+// pdfLargestCount returns the largest /Count of a page tree node in data.
+func pdfLargestCount(data []byte) int {
+	best := 0
+	for _, m := range pdfPageCount.FindAllSubmatch(data, -1) {
+		digits := m[1]
+		if len(digits) == 0 {
+			digits = m[2]
+		}
+		if n, err := strconv.Atoi(string(digits)); err == nil && n > best {
+			best = n
+		}
+	}
+	return best
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// pdfBuilder writes a PDF with a classic cross-reference table, so tests
+// can place the page tree anywhere in the file.
+type pdfBuilder struct {
+	buf     bytes.Buffer
+	offsets map[int]int
+}
+
+func newPDFBuilder() *pdfBuilder {
+	b := &pdfBuilder{offsets: make(map[int]int)}
+	b.buf.WriteString("%PDF-1.4\n")
+	return b
+}
+
+func (b *pdfBuilder) object(num int, body string) {
+	b.offsets[num] = b.buf.Len()
+	fmt.Fprintf(&b.buf, "%d 0 obj\n%s\nendobj\n", num, body)
+}
+
+// padding adds a stream object of n filler bytes.
+func (b *pdfBuilder) padding(num, n int) {
+	b.object(num, fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", n, strings.Repeat("x", n)))
+}
+
+// xref writes a table for objects nums, then the trailer. prev is the
+// previous table's offset, or 0 for none.
+func (b *pdfBuilder) xref(nums []int, size, prev int) int {
+	offset := b.buf.Len()
+	b.buf.WriteString("xref\n")
+	if prev == 0 {
+		b.buf.WriteString("0 1\n0000000000 65535 f\r\n")
+	}
+	for _, num := range nums {
+		fmt.Fprintf(&b.buf, "%d 1\n%010d 00000 n\r\n", num, b.offsets[num])
+	}
+	b.buf.WriteString("trailer\n<< /Size " + fmt.Sprint(size) + " /Root 1 0 R")
+	if prev != 0 {
+		fmt.Fprintf(&b.buf, " /Prev %d", prev)
+	}
+	fmt.Fprintf(&b.buf, " >>\nstartxref\n%d\n%%%%EOF\n", offset)
+	return offset
+}
+
+// countingReader counts the bytes read through ReadAt.
+type countingReader struct {
+	r    io.ReaderAt
+	read int
+}
+
+func (c *countingReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.read += n
+	return n, err
+}
+
+// buildPagedPDF returns a PDF of pages pages whose page tree sits between
+// two pad-byte streams, out of reach of a scan of either end.
+func buildPagedPDF(pad, pages int) (*pdfBuilder, []int) {
+	b := newPDFBuilder()
+	b.object(1, "<< /Type /Catalog /Pages 3 0 R >>")
+	b.padding(2, pad)
+	var kids []string
+	for i := 0; i < pages; i++ {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 10+i))
+	}
+	b.object(3, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), pages))
+	nums := []int{1, 2, 3}
+	for i := 0; i < pages; i++ {
+		b.object(10+i, "<< /Type /Page /Parent 3 0 R >>")
+		nums = append(nums, 10+i)
+	}
+	b.padding(4, pad)
+	return b, append(nums, 4)
+}
+
+func TestPDFPageTotalReadsLittle(t *testing.T) {
+	b, nums := buildPagedPDF(4<<20, 3)
+	b.xref(nums, 20, 0)
+	data := b.buf.Bytes()
+
+	r := &countingReader{r: bytes.NewReader(data)}
+	if pages := pdfPageTotal(r, int64(len(data))); pages != 3 {
+		t.Errorf("pages = %d, want 3", pages)
+	}
+	if r.read > 256<<10 {
+		t.Errorf("read %d bytes of a %d byte PDF", r.read, len(data))
+	}
+}
+
+// An incremental update that replaces the page tree is found through the
+// newest table, and objects it leaves alone through /Prev.
+func TestPDFPageTotalFollowsUpdates(t *testing.T) {
+	b, nums := buildPagedPDF(4<<20, 3)
+	first := b.xref(nums, 20, 0)
+	b.object(3, "<< /Type /Pages /Kids [10 0 R 11 0 R 12 0 R 20 0 R 21 0 R] /Count 5 >>")
+	b.object(20, "<< /Type /Page /Parent 3 0 R >>")
+	b.object(21, "<< /Type /Page /Parent 3 0 R >>")
+	b.xref([]int{3, 20, 21}, 22, first)
+	data := b.buf.Bytes()
+
+	if pages := pdfPageTotal(bytes.NewReader(data), int64(len(data))); pages != 5 {
+		t.Errorf("pages = %d, want 5", pages)
+	}
+}
+
+// Without a usable cross-reference table, a small PDF is scanned whole and
+// a large one at either end.
+func TestPDFPageTotalFallsBackToScanning(t *testing.T) {
+	small := []byte("%PDF-1.4\n1 0 obj << /Type /Page >> endobj\n2 0 obj << /Type /Page >> endobj\n%%EOF\n")
+	if pages := pdfPageTotal(bytes.NewReader(small), int64(len(small))); pages != 2 {
+		t.Errorf("small: pages = %d, want 2", pages)
+	}
+
+	b := newPDFBuilder()
+	b.object(3, "<< /Type /Pages /Count 7 >>")
+	b.padding(2, 3<<20)
+	b.buf.WriteString("startxref\n999999999\n%%EOF\n")
+	data := b.buf.Bytes()
+	r := &countingReader{r: bytes.NewReader(data)}
+	if pages := pdfPageTotal(r, int64(len(data))); pages != 7 {
+		t.Errorf("large: pages = %d, want 7", pages)
+	}
+	if r.read > 2*pdfWindowScan+pdfTrailerScan {
+		t.Errorf("large: read %d bytes of a %d byte PDF", r.read, len(data))
+	}
+}
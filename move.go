@@ -0,0 +1,164 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Event names for track_moves; neither is selectable in record_events.
+const (
+	eventMove   = "move"
+	eventRemove = "remove"
+)
+
+// defaultMoveWindow is how long a rename waits for its matching create
+// when move_window is unset.
+const defaultMoveWindow = 500 * time.Millisecond
+
+// fileID identifies a file independently of its path.
+type fileID struct {
+	inode, device uint64
+}
+
+// pendingMove is a rename still waiting for the create of its new path.
+type pendingMove struct {
+	from  string
+	timer *time.Timer
+}
+
+// moveTracker pairs the Rename fsnotify reports on a file's old path with
+// the Create on its new path. The inode of every recorded file is
+// remembered, since the old path can no longer be statted once the rename
+// is seen. A rename is matched with a create of the same inode within the
+// window; unmatched renames are reported through removed.
+type moveTracker struct {
+	window  time.Duration
+	removed func(path string, id fileID)
+
+	mu      sync.Mutex
+	known   map[string]fileID // by pathKey
+	pending map[fileID]*pendingMove
+	closed  bool
+}
+
+func newMoveTracker(window time.Duration, removed func(path string, id fileID)) *moveTracker {
+	if window == 0 {
+		window = defaultMoveWindow
+	}
+	return &moveTracker{
+		window:  window,
+		removed: removed,
+		known:   make(map[string]fileID),
+		pending: make(map[fileID]*pendingMove),
+	}
+}
+
+// remember notes the identity of a recorded file.
+func (m *moveTracker) remember(path string, id fileID) {
+	if id.inode == 0 {
+		return
+	}
+	m.mu.Lock()
+	m.known[pathKey(path)] = id
+	m.mu.Unlock()
+}
+
+// forget drops path, which has been removed.
+func (m *moveTracker) forget(path string) {
+	m.mu.Lock()
+	delete(m.known, pathKey(path))
+	m.mu.Unlock()
+}
+
+// observe updates the tracker with a watcher event and returns the move
+// record when event is the create that completes a pending rename. A
+// Rename that also carries Write (see platformEvent) names a path that
+// still exists: the file there was replaced, not moved away, so it does
+// not start a move.
+func (m *moveTracker) observe(event fsnotify.Event) (fileEvent, bool) {
+	switch {
+	case event.Op&fsnotify.Rename != 0:
+		if event.Op&fsnotify.Write == 0 {
+			m.renamed(event.Name)
+		}
+	case event.Op&fsnotify.Remove != 0:
+		m.forget(event.Name)
+	case event.Op&fsnotify.Create != 0:
+		if from, ok := m.created(event.Name); ok {
+			return fileEvent{Path: event.Name, Event: eventMove, From: from}, true
+		}
+	}
+	return fileEvent{}, false
+}
+
+// renamed starts waiting for the new name of path.
+func (m *moveTracker) renamed(path string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id, ok := m.known[pathKey(path)]
+	if !ok || m.closed {
+		return
+	}
+	delete(m.known, pathKey(path))
+	if prev, ok := m.pending[id]; ok {
+		prev.timer.Stop()
+	}
+	p := &pendingMove{from: path}
+	p.timer = time.AfterFunc(m.window, func() { m.expire(id, p) })
+	m.pending[id] = p
+}
+
+// created returns the old path when path is the new name of a pending
+// rename.
+func (m *moveTracker) created(path string) (from string, ok bool) {
+	m.mu.Lock()
+	empty := len(m.pending) == 0
+	m.mu.Unlock()
+	if empty {
+		return "", false
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return "", false
+	}
+	inode, device := fileIdentity(info)
+	id := fileID{inode, device}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.pending[id]
+	if !ok {
+		return "", false
+	}
+	p.timer.Stop()
+	delete(m.pending, id)
+	return p.from, true
+}
+
+func (m *moveTracker) expire(id fileID, p *pendingMove) {
+	m.mu.Lock()
+	if m.pending[id] != p {
+		m.mu.Unlock()
+		return
+	}
+	delete(m.pending, id)
+	m.mu.Unlock()
+	m.removed(p.from, id)
+}
+
+// flush reports every pending rename as a removal. It is called on
+// shutdown, after which renames are ignored.
+func (m *moveTracker) flush() {
+	m.mu.Lock()
+	m.closed = true
+	pending := m.pending
+	m.pending = make(map[fileID]*pendingMove)
+	m.mu.Unlock()
+	for id, p := range pending {
+		p.timer.Stop()
+		m.removed(p.from, id)
+	}
+}
@@ -0,0 +1,70 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// openForWriting reports whether a process other than this one has path
+// open for writing. It scans the file descriptors under /proc, so it sees
+// only the processes this user may inspect. A descriptor's access mode is
+// read from the flags line of its fdinfo.
+func openForWriting(path string) (bool, error) {
+	target, err := filepath.Abs(path)
+	if err != nil {
+		return false, err
+	}
+	procs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return false, err
+	}
+	self := strconv.Itoa(os.Getpid())
+	for _, proc := range procs {
+		pid := proc.Name()
+		if pid == self || pid[0] < '0' || pid[0] > '9' {
+			continue
+		}
+		fdDir := filepath.Join("/proc", pid, "fd")
+		fds, err := ioutil.ReadDir(fdDir)
+		if err != nil {
+			// Exited, or not ours to inspect
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || link != target {
+				continue
+			}
+			if writableFD(filepath.Join("/proc", pid, "fdinfo", fd.Name())) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// writableFD reports whether the fdinfo file at path describes a
+// descriptor opened with O_WRONLY or O_RDWR.
+func writableFD(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "flags:") {
+			continue
+		}
+		flags, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "flags:")), 8, 64)
+		return err == nil && flags&uint64(os.O_WRONLY|os.O_RDWR) != 0
+	}
+	return false
+}
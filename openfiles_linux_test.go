@@ -0,0 +1,42 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWritableFD(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.csv")
+	for _, tt := range []struct {
+		flag     int
+		writable bool
+	}{
+		{os.O_RDONLY | os.O_CREATE, false},
+		{os.O_WRONLY, true},
+		{os.O_RDWR | os.O_APPEND, true},
+	} {
+		f, err := os.OpenFile(path, tt.flag, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fdinfo := fmt.Sprintf("/proc/self/fdinfo/%d", f.Fd())
+		if got := writableFD(fdinfo); got != tt.writable {
+			t.Errorf("flag %#o: writable = %v, want %v", tt.flag, got, tt.writable)
+		}
+		f.Close()
+	}
+
+	// This process's own descriptors are never counted
+	f, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if open, err := openForWriting(path); err != nil || open {
+		t.Errorf("openForWriting = %v, %v; want false for our own descriptor", open, err)
+	}
+}
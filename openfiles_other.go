@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+// openForWriting is only implemented on Linux, so skip_open_files has no
+// effect elsewhere.
+func openForWriting(path string) (bool, error) {
+	return false, nil
+}
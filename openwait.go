@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// openFileRecheck is how often a file deferred by skip_open_files is
+// checked again.
+const openFileRecheck = time.Second
+
+// openWaiter holds back events for files another process still has open
+// for writing, resubmitting each one every openFileRecheck until the file
+// is closed. Events still waiting at shutdown are dropped with a log line.
+type openWaiter struct {
+	resubmit func(fileEvent)
+
+	mu       sync.Mutex
+	pending  map[string]*time.Timer // by pathKey
+	closed   bool
+	inFlight sync.WaitGroup
+}
+
+func newOpenWaiter(resubmit func(fileEvent)) *openWaiter {
+	return &openWaiter{resubmit: resubmit, pending: make(map[string]*time.Timer)}
+}
+
+// wait schedules ev to be processed again later.
+func (w *openWaiter) wait(ev fileEvent) {
+	key := pathKey(ev.Path)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		log.Printf("Not recording %s: still open for writing at shutdown", ev.Path)
+		return
+	}
+	if _, ok := w.pending[key]; ok {
+		return
+	}
+	w.pending[key] = time.AfterFunc(openFileRecheck, func() {
+		w.mu.Lock()
+		if w.closed {
+			w.mu.Unlock()
+			return
+		}
+		delete(w.pending, key)
+		w.inFlight.Add(1)
+		w.mu.Unlock()
+		defer w.inFlight.Done()
+		w.resubmit(ev)
+	})
+}
+
+// close stops all rechecks and waits for resubmissions already under way.
+// It is called on shutdown before the event queue is closed.
+func (w *openWaiter) close() {
+	w.mu.Lock()
+	w.closed = true
+	for key, timer := range w.pending {
+		timer.Stop()
+		log.Printf("Not recording %s: still open for writing at shutdown", key)
+	}
+	w.pending = nil
+	w.mu.Unlock()
+	w.inFlight.Wait()
+}
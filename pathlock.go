@@ -0,0 +1,45 @@
+package main
+
+import "sync"
+
+// pathLocks serializes the processing of each path. Workers handling
+// different files run in parallel, but two events for the same file are
+// processed one after the other, so its records are numbered in the order
+// it was statted and a growing file's recorded size never goes backwards.
+type pathLocks struct {
+	mu    sync.Mutex
+	locks map[string]*pathLock // by pathKey
+}
+
+type pathLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func newPathLocks() *pathLocks {
+	return &pathLocks{locks: make(map[string]*pathLock)}
+}
+
+// lock waits until no other worker holds path and returns the function
+// that releases it.
+func (l *pathLocks) lock(path string) (unlock func()) {
+	key := pathKey(path)
+	l.mu.Lock()
+	pl, ok := l.locks[key]
+	if !ok {
+		pl = &pathLock{}
+		l.locks[key] = pl
+	}
+	pl.refs++
+	l.mu.Unlock()
+
+	pl.mu.Lock()
+	return func() {
+		pl.mu.Unlock()
+		l.mu.Lock()
+		if pl.refs--; pl.refs == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watch modes accepted by the watch_mode setting.
+const (
+	watchModeNotify = "notify"
+	watchModePoll   = "poll"
+)
+
+const (
+	defaultPollInterval = 2 * time.Second
+
+	// pollHashWindow covers the coarsest common mtime granularity (FAT
+	// stores times in 2s steps). A file modified this close to a scan can
+	// change again without its mtime moving, so poll_hash re-checks its
+	// content on the next scan.
+	pollHashWindow = 2 * time.Second
+)
+
+// watchSource produces filesystem events until closed.
+type watchSource interface {
+	run(handle func(fsnotify.Event))
+	close()
+}
+
+// fileState is what the poller remembers about a file between scans.
+type fileState struct {
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+// poller detects changes by rescanning the watch roots every interval and
+// comparing each file's size and mtime with the previous scan. With hash
+// set, content hashes catch the changes that comparison misses and filter
+// out mtime-only touches.
+type poller struct {
+	roots     []string
+	recursive bool
+	maxDepth  int
+	interval  time.Duration
+	hash      bool
+	openFiles semaphore
+
+	files    map[string]fileState
+	lastScan time.Time
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newPoller(roots []string, config Config, openFiles semaphore) *poller {
+	interval := config.PollInterval
+	if interval == 0 {
+		interval = defaultPollInterval
+	}
+	return &poller{
+		roots:     roots,
+		recursive: config.Recursive,
+		maxDepth:  config.MaxDepth,
+		interval:  interval,
+		hash:      config.PollHash,
+		openFiles: openFiles,
+		done:      make(chan struct{}),
+	}
+}
+
+// run takes a baseline scan, then reports differences found by each later
+// scan until close is called. Files present at startup are not reported.
+func (p *poller) run(handle func(fsnotify.Event)) {
+	p.scan(nil)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.scan(handle)
+		}
+	}
+}
+
+func (p *poller) close() {
+	p.closeOnce.Do(func() { close(p.done) })
+}
+
+// scan walks the roots, reporting created, modified and removed files to
+// handle when it is not nil.
+func (p *poller) scan(handle func(fsnotify.Event)) {
+	now := time.Now()
+	emit := func(path string, op fsnotify.Op) {
+		if handle != nil {
+			handle(fsnotify.Event{Name: path, Op: op})
+		}
+	}
+
+	current := make(map[string]fileState, len(p.files))
+	for _, root := range p.roots {
+		filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				log.Printf("Failed to scan %s: %v", path, err)
+				return nil
+			}
+			if info.IsDir() {
+				if path != root && (!p.recursive || !withinDepth(root, path, p.maxDepth) || nestedRoot(p.roots, root, path)) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if _, done := current[path]; done {
+				// Already reached through an overlapping root
+				return nil
+			}
+
+			// Pipes and devices are never hashed: reading a FIFO blocks
+			// forever
+			hash := p.hash && info.Mode().IsRegular()
+
+			prev, seen := p.files[path]
+			state := fileState{size: info.Size(), modTime: info.ModTime(), hash: prev.hash}
+			changed := seen && (state.size != prev.size || !state.modTime.Equal(prev.modTime))
+			switch {
+			case !seen:
+				emit(path, fsnotify.Create)
+				state.hash = ""
+			case changed && hash:
+				state.hash = p.hashOf(path)
+				if prev.hash != "" && state.hash == prev.hash {
+					// Only the metadata moved
+					break
+				}
+				emit(path, fsnotify.Write)
+			case changed:
+				emit(path, fsnotify.Write)
+			case hash && !prev.modTime.Before(p.lastScan.Add(-pollHashWindow)):
+				// Size and mtime match, but the mtime is too close to the
+				// last scan to rule out a change within the same tick
+				state.hash = p.hashOf(path)
+				if prev.hash != "" && state.hash != prev.hash {
+					emit(path, fsnotify.Write)
+				}
+			}
+
+			// Remember the content of recently modified files so the next
+			// scan has something to compare against
+			if hash && state.hash == "" && !state.modTime.Before(now.Add(-pollHashWindow)) {
+				state.hash = p.hashOf(path)
+			}
+			current[path] = state
+			return nil
+		})
+	}
+
+	for path := range p.files {
+		if _, ok := current[path]; !ok {
+			emit(path, fsnotify.Remove)
+		}
+	}
+	p.files = current
+	p.lastScan = now
+}
+
+// hashOf hashes path under the open-file limit, returning "" on failure.
+func (p *poller) hashOf(path string) string {
+	p.openFiles.acquire()
+	defer p.openFiles.release()
+	sum, err := hashFile(path)
+	if err != nil {
+		log.Printf("Failed to hash %s: %v", path, err)
+		return ""
+	}
+	return sum
+}
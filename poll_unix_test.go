@@ -0,0 +1,39 @@
+//go:build unix
+
+package main
+
+import (
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// A FIFO in a polled tree must not be opened for hashing: the open would
+// block the poller forever.
+func TestPollHashSkipsFIFO(t *testing.T) {
+	root := t.TempDir()
+	fifo := filepath.Join(root, "pipe")
+	if err := syscall.Mkfifo(fifo, 0644); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+	p := &poller{roots: []string{root}, hash: true, openFiles: newSemaphore(1)}
+
+	done := make(chan []fsnotify.Event)
+	go func() {
+		var events []fsnotify.Event
+		p.scan(nil)
+		p.scan(func(ev fsnotify.Event) { events = append(events, ev) })
+		done <- events
+	}()
+	select {
+	case events := <-done:
+		if len(events) != 0 {
+			t.Errorf("unchanged FIFO reported %v", events)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("scan blocked on the FIFO")
+	}
+}
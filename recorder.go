@@ -0,0 +1,222 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+// defaultMaxHeldRecords caps the records held while the storage disk is
+// low when max_held_records is not set.
+const defaultMaxHeldRecords = 100000
+
+// checkFreeDisk is freeDiskBytes, replaced in tests.
+var checkFreeDisk = freeDiskBytes
+
+// recorder buffers records and writes them to storage in batches. A batch
+// is written once it holds batchSize records or flushInterval has passed,
+// whichever comes first; a zero batchSize means only the interval applies.
+// All storage writes go through the recorder, which serializes them.
+//
+// When minFreeDisk is set, writes are held back while the storage
+// filesystem has less free space than that, so a nearly full disk cannot
+// leave the storage file half written. Held records stay buffered and are
+// written once space is available again, up to maxHeld of them: past that
+// the oldest is dropped for each new record. Records whose write failed
+// are held the same way and retried on the next flush.
+//
+// A templated storage_location sends each record to the file it resolves
+// to, keeping the ndjson and csv partition files open in an LRU cache.
+type recorder struct {
+	storageLocation string
+	storageTmpl     *template.Template
+	handles         *handleCache
+	format          string
+	batchSize       int
+	minFreeDisk     uint64
+	maxHeld         int
+	openFiles       semaphore
+
+	mu           sync.Mutex
+	pending      []interface{}
+	diskLow      int32
+	writeFailing int32
+	heldDropped  uint64 // records dropped while writes are held
+
+	done    chan struct{}
+	stopped sync.WaitGroup
+}
+
+func newRecorder(config Config, openFiles semaphore) *recorder {
+	batchSize := config.BatchSize
+	if batchSize == 0 && config.FlushInterval == 0 {
+		// Neither is set: write every record as it arrives
+		batchSize = 1
+	}
+	r := &recorder{
+		storageLocation: config.StorageLocation,
+		format:          config.Format,
+		batchSize:       batchSize,
+		minFreeDisk:     uint64(config.MinFreeDiskBytes),
+		maxHeld:         config.MaxHeldRecords,
+		openFiles:       openFiles,
+		done:            make(chan struct{}),
+	}
+	if r.maxHeld == 0 {
+		r.maxHeld = defaultMaxHeldRecords
+	}
+	if isStorageTemplate(config.StorageLocation) {
+		// validateConfig has already parsed the template
+		r.storageTmpl, _ = parseStorageTemplate(config.StorageLocation)
+		r.handles = newHandleCache(config.StorageOpenFiles)
+	}
+	if config.FlushInterval > 0 {
+		r.stopped.Add(1)
+		go r.flushEvery(config.FlushInterval)
+	}
+	return r
+}
+
+// add buffers a FileData or DirAggregate record, writing the batch if it
+// is now full.
+func (r *recorder) add(record interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending = append(r.pending, record)
+	if len(r.pending) > r.maxHeld && !r.healthy() {
+		r.pending[0] = nil
+		r.pending = r.pending[1:]
+		r.heldDropped++
+	}
+	if r.batchSize > 0 && len(r.pending) >= r.batchSize {
+		r.flushLocked()
+	}
+}
+
+// flush writes any buffered records.
+func (r *recorder) flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.flushLocked()
+}
+
+func (r *recorder) flushLocked() {
+	if len(r.pending) == 0 {
+		return
+	}
+	if !r.diskSpaceOK() {
+		return
+	}
+
+	// Hold an open-file slot while the storage file is written
+	r.openFiles.acquire()
+	var failed []interface{}
+	var err error
+	if r.storageTmpl != nil {
+		failed, err = appendPartitioned(r.storageTmpl, storageBaseDir(r.storageLocation), r.format, r.handles, r.pending)
+	} else if err = appendRecords(r.storageLocation, r.format, r.pending); err != nil {
+		failed = r.pending
+	}
+	r.openFiles.release()
+
+	// Keep what was not written for the next flush
+	r.pending = failed
+	if err != nil {
+		atomic.StoreInt32(&r.writeFailing, 1)
+		log.Printf("Failed to record %d events, holding them for the next flush: %v", len(failed), err)
+		return
+	}
+	if atomic.SwapInt32(&r.writeFailing, 0) == 1 {
+		log.Printf("Storage writes to %s recovered; %d older held records were dropped past max_held_records",
+			r.storageLocation, r.heldDropped)
+		r.heldDropped = 0
+	}
+}
+
+// diskSpaceOK reports whether the storage filesystem has at least
+// minFreeDisk bytes free, logging when that changes. A failed check allows
+// the write, which then reports its own error.
+func (r *recorder) diskSpaceOK() bool {
+	if r.minFreeDisk == 0 {
+		return true
+	}
+	var dir string
+	if r.storageTmpl != nil {
+		dir = existingAncestor(storageBaseDir(r.storageLocation))
+	} else {
+		dir = filepath.Dir(r.storageLocation)
+	}
+	free, ok, err := checkFreeDisk(dir)
+	if err != nil {
+		log.Printf("Failed to check free disk space for %s: %v", r.storageLocation, err)
+		return true
+	}
+	if !ok {
+		return true
+	}
+	if free < r.minFreeDisk {
+		if atomic.SwapInt32(&r.diskLow, 1) == 0 {
+			log.Printf("CRITICAL: only %d bytes free for %s, below min_free_disk_bytes %d; holding %d records until space is freed",
+				free, r.storageLocation, r.minFreeDisk, len(r.pending))
+		}
+		return false
+	}
+	if atomic.SwapInt32(&r.diskLow, 0) == 1 {
+		log.Printf("Disk space for %s recovered, writing %d held records; %d older ones were dropped past max_held_records",
+			r.storageLocation, len(r.pending), r.heldDropped)
+		r.heldDropped = 0
+	}
+	return true
+}
+
+// healthy reports whether storage writes are going ahead.
+func (r *recorder) healthy() bool {
+	return r.holdReason() == ""
+}
+
+// holdReason says why records are being held back, or is empty when
+// storage writes are going ahead.
+func (r *recorder) holdReason() string {
+	switch {
+	case atomic.LoadInt32(&r.diskLow) == 1:
+		return "storage disk low"
+	case atomic.LoadInt32(&r.writeFailing) == 1:
+		return "storage writes failing"
+	}
+	return ""
+}
+
+func (r *recorder) flushEvery(interval time.Duration) {
+	defer r.stopped.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.flush()
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// close stops the flush timer and writes whatever is still buffered.
+// Records still held back by low disk space or a failing write are lost,
+// and counted in the log.
+func (r *recorder) close() {
+	close(r.done)
+	r.stopped.Wait()
+	r.mu.Lock()
+	r.flushLocked()
+	if len(r.pending) > 0 {
+		log.Printf("CRITICAL: %s for %s at shutdown; %d held records lost, %d dropped earlier past max_held_records",
+			r.holdReason(), r.storageLocation, len(r.pending), r.heldDropped)
+	}
+	r.mu.Unlock()
+	if r.handles != nil {
+		r.handles.closeAll()
+	}
+}
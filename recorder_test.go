@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// lowDisk replaces the free space check with one reporting free bytes,
+// which the test can change, until the test ends.
+func lowDisk(t *testing.T, free *uint64) {
+	saved := checkFreeDisk
+	checkFreeDisk = func(string) (uint64, bool, error) {
+		return atomic.LoadUint64(free), true, nil
+	}
+	t.Cleanup(func() { checkFreeDisk = saved })
+}
+
+func TestRecorderCapsHeldRecords(t *testing.T) {
+	free := uint64(10)
+	lowDisk(t, &free)
+	storage := filepath.Join(t.TempDir(), "events.ndjson")
+	r := newRecorder(Config{StorageLocation: storage, Format: formatNDJSON, MinFreeDiskBytes: 100, MaxHeldRecords: 3}, newSemaphore(1))
+
+	for seq := uint64(1); seq <= 5; seq++ {
+		r.add(FileData{Seq: seq, Path: "/w/a.txt"})
+	}
+	if r.healthy() {
+		t.Error("healthy while the disk is low")
+	}
+	if _, err := os.Stat(storage); !os.IsNotExist(err) {
+		t.Errorf("storage written while the disk is low: %v", err)
+	}
+
+	atomic.StoreUint64(&free, 1000)
+	r.close()
+	records, err := readRecords(storage, formatNDJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seqs []uint64
+	for _, record := range records {
+		seqs = append(seqs, record.Seq)
+	}
+	if len(seqs) != 3 || seqs[0] != 3 || seqs[2] != 5 {
+		t.Errorf("wrote seqs %v, want the newest three, 3 to 5", seqs)
+	}
+}
+
+func TestRecorderLogsRecordsLostAtShutdown(t *testing.T) {
+	free := uint64(10)
+	lowDisk(t, &free)
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	storage := filepath.Join(t.TempDir(), "events.ndjson")
+	r := newRecorder(Config{StorageLocation: storage, Format: formatNDJSON, MinFreeDiskBytes: 100, MaxHeldRecords: 2}, newSemaphore(1))
+	for seq := uint64(1); seq <= 3; seq++ {
+		r.add(FileData{Seq: seq, Path: "/w/a.txt"})
+	}
+	r.close()
+
+	if want := "2 held records lost, 1 dropped earlier"; !strings.Contains(logged.String(), want) {
+		t.Errorf("log %q does not report %q", logged.String(), want)
+	}
+}
+
+// A failed write keeps its records, capped like those held for low disk
+// space, and the next flush writes them.
+func TestRecorderRetriesFailedWrites(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	storage := filepath.Join(dir, "events.ndjson")
+	r := newRecorder(Config{StorageLocation: storage, Format: formatNDJSON, MaxHeldRecords: 3}, newSemaphore(1))
+
+	for seq := uint64(1); seq <= 5; seq++ {
+		r.add(FileData{Seq: seq, Path: "/w/a.txt"})
+	}
+	if got := r.holdReason(); got != "storage writes failing" {
+		t.Errorf("hold reason = %q while writes fail", got)
+	}
+
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	r.flush()
+	if !r.healthy() {
+		t.Error("not healthy once the write succeeded")
+	}
+	r.close()
+	records, err := readRecords(storage, formatNDJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seqs []uint64
+	for _, record := range records {
+		seqs = append(seqs, record.Seq)
+	}
+	if len(seqs) != 3 || seqs[0] != 3 || seqs[2] != 5 {
+		t.Errorf("wrote seqs %v, want the newest three, 3 to 5", seqs)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// fitRecord drops optional enrichment from fileData until its JSON
+// encoding is at most max bytes: first metadata, then sidecar tags, then
+// the content types, key and fuzzy hash. Core fields such as path and size
+// are always kept, so a record with a very long path may still exceed max.
+// Dropped fields are flagged with truncated.
+func fitRecord(fileData FileData, max int) FileData {
+	steps := []func(*FileData){
+		func(fd *FileData) { fd.Metadata = nil },
+		func(fd *FileData) { fd.Tags = nil },
+		func(fd *FileData) { fd.ContentType, fd.InnerContentType, fd.Key, fd.FuzzyHash = "", "", "", "" },
+	}
+	size := recordSize(fileData)
+	if size <= max {
+		return fileData
+	}
+	original := size
+	for _, drop := range steps {
+		drop(&fileData)
+		fileData.Truncated = true
+		if size = recordSize(fileData); size <= max {
+			break
+		}
+	}
+	if size > max {
+		log.Printf("Warning: record for %s is %d bytes, over max_record_bytes %d even without optional fields", fileData.Path, size, max)
+	} else {
+		log.Printf("Warning: record for %s was %d bytes, over max_record_bytes %d; optional fields dropped", fileData.Path, original, max)
+	}
+	return fileData
+}
+
+// recordSize returns the length of fileData's JSON encoding.
+func recordSize(fileData FileData) int {
+	data, err := json.Marshal(fileData)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
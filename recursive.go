@@ -0,0 +1,46 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dirDepth returns how many levels dir is below root; root itself is 0.
+func dirDepth(root, dir string) int {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return len(strings.Split(rel, string(filepath.Separator)))
+}
+
+// withinDepth reports whether dir may be watched under root given maxDepth,
+// where 0 means unlimited.
+func withinDepth(root, dir string, maxDepth int) bool {
+	return maxDepth == 0 || dirDepth(root, dir) <= maxDepth
+}
+
+// watchTree registers dir and every directory below it with source,
+// skipping anything deeper than maxDepth levels under root. It is used both
+// for the initial walk and when a new directory appears, since the new
+// directory may already contain subdirectories by the time it is seen.
+func watchTree(source *eventSource, root, dir string, maxDepth int, globs *globFilter) {
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Printf("Failed to walk %s: %v", path, err)
+			return nil
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if !withinDepth(root, path, maxDepth) || globs.excluded(path) {
+			return filepath.SkipDir
+		}
+		if err := source.add(path); err != nil {
+			log.Printf("Failed to watch %s: %v", path, err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// mkdirs creates each directory, relative to root, with a file.txt in it.
+func mkdirs(t *testing.T, root string, dirs ...string) {
+	t.Helper()
+	for _, dir := range dirs {
+		dir = filepath.Join(root, dir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "file.txt"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDirDepth(t *testing.T) {
+	root := filepath.FromSlash("/w")
+	for _, tt := range []struct {
+		dir  string
+		want int
+	}{
+		{"/w", 0},
+		{"/w/a", 1},
+		{"/w/a/b", 2},
+	} {
+		if got := dirDepth(root, filepath.FromSlash(tt.dir)); got != tt.want {
+			t.Errorf("dirDepth(%s) = %d, want %d", tt.dir, got, tt.want)
+		}
+	}
+}
+
+// With max_depth 2, files in root/a/b are recorded and files in
+// root/a/b/c are not, both by the startup scan and by the watches.
+func TestMaxDepth(t *testing.T) {
+	root := t.TempDir()
+	mkdirs(t, root, ".", "a", "a/b", "a/b/c")
+	globs, err := newGlobFilter([]string{root}, nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := Config{Recursive: true, MaxDepth: 2}
+
+	queue := make(chan fileEvent, 10)
+	newStartupScan([]string{root}, config, nil, globs, queue).run()
+	close(queue)
+	var scanned []string
+	for ev := range queue {
+		scanned = append(scanned, ev.Path)
+	}
+	sort.Strings(scanned)
+	want := []string{
+		filepath.Join(root, "a", "b", "file.txt"),
+		filepath.Join(root, "a", "file.txt"),
+		filepath.Join(root, "file.txt"),
+	}
+	if !equalStrings(scanned, want) {
+		t.Errorf("scanned %v, want %v", scanned, want)
+	}
+
+	made := make(chan *fakeWatcher, 1)
+	source, err := newEventSourceWith(nil, fakeWatchers(nil, made))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := nextWatcher(t, made)
+	watchTree(source, root, root, config.MaxDepth, globs)
+	want = []string{root, filepath.Join(root, "a"), filepath.Join(root, "a", "b")}
+	if got := w.dirs(); !equalStrings(got, want) {
+		t.Errorf("watched %v, want %v", got, want)
+	}
+}
+
+// A directory created at runtime is watched along with the directories
+// already inside it, within max_depth.
+func TestTrackDirectoryWatchesNewTree(t *testing.T) {
+	root := t.TempDir()
+	globs, err := newGlobFilter([]string{root}, nil, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	made := make(chan *fakeWatcher, 1)
+	source, err := newEventSourceWith([]string{root}, fakeWatchers(nil, made))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := nextWatcher(t, made)
+
+	mkdirs(t, root, "new/sub/deep")
+	created := filepath.Join(root, "new")
+	trackDirectory(source, []string{root}, 2, globs, fsnotify.Event{Name: created, Op: fsnotify.Create})
+	want := []string{root, created, filepath.Join(created, "sub")}
+	if got := w.dirs(); !equalStrings(got, want) {
+		t.Errorf("watched %v, want %v", got, want)
+	}
+	if !source.watching(created) {
+		t.Errorf("%s is not kept for rebuilds", created)
+	}
+
+	trackDirectory(source, []string{root}, 2, globs, fsnotify.Event{Name: filepath.Join(created, "file.txt"), Op: fsnotify.Create})
+	if got := w.dirs(); !equalStrings(got, want) {
+		t.Errorf("a new file changed the watches to %v", got)
+	}
+
+	trackDirectory(source, []string{root}, 2, globs, fsnotify.Event{Name: created, Op: fsnotify.Remove})
+	if source.watching(created) {
+		t.Errorf("%s is still kept after its removal", created)
+	}
+}
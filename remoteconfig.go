@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	_ "github.com/spf13/viper/remote"
+)
+
+// defaultRemoteFormat is how the remote value is parsed when remote_format
+// is unset.
+const defaultRemoteFormat = "yaml"
+
+// knownRemoteProvider reports whether viper can read from provider.
+func knownRemoteProvider(provider string) bool {
+	switch provider {
+	case "consul", "etcd", "etcd3", "firestore":
+		return true
+	}
+	return false
+}
+
+// loadRemoteConfig reads the configuration stored under remote_key and
+// merges it over the local file, so centrally managed settings win. The
+// remote_* settings themselves come from the local file.
+func loadRemoteConfig(config Config) (*viper.Viper, error) {
+	format := config.RemoteFormat
+	if format == "" {
+		format = defaultRemoteFormat
+	}
+	remote := viper.New()
+	if err := remote.AddRemoteProvider(config.RemoteProvider, config.RemoteEndpoint, config.RemoteKey); err != nil {
+		return nil, err
+	}
+	remote.SetConfigType(format)
+	if err := remote.ReadRemoteConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read %s from %s: %v", config.RemoteKey, config.RemoteEndpoint, err)
+	}
+	if err := viper.MergeConfigMap(remote.AllSettings()); err != nil {
+		return nil, err
+	}
+	return remote, nil
+}
+
+// watchRemoteConfig re-reads the remote configuration every interval.
+// When settings in liveSettingKeys changed, the configuration is rebuilt
+// from the local file at configPath and the remote values as at startup,
+// and passed to apply; an invalid one is logged and the running settings
+// are kept. Changes to any other setting are logged and take effect on
+// the next restart.
+func watchRemoteConfig(remote *viper.Viper, configPath string, interval time.Duration, apply func(Config) error) {
+	last := remote.AllSettings()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := remote.WatchRemoteConfig(); err != nil {
+			log.Printf("Failed to refresh remote config: %v", err)
+			continue
+		}
+		current := remote.AllSettings()
+		changed := changedSettings(last, current)
+		last = current
+
+		var live, restart []string
+		for _, key := range changed {
+			if liveSettingKeys[strings.SplitN(key, ".", 2)[0]] {
+				live = append(live, key)
+			} else {
+				restart = append(restart, key)
+			}
+		}
+		if len(restart) > 0 {
+			log.Printf("Remote config changed (%v); restart to apply", restart)
+		}
+		if len(live) == 0 {
+			continue
+		}
+		if err := reloadRemoteConfig(configPath, current, apply); err != nil {
+			log.Printf("Not applying remote config change (%v): %v", live, err)
+			continue
+		}
+		log.Printf("Applied remote config change (%v)", live)
+	}
+}
+
+// reloadRemoteConfig reads the local file at configPath into a fresh
+// viper, merges settings over it and passes the result to apply. Starting
+// afresh each time means a key removed from the remote value falls back to
+// the local file or the default instead of keeping its last remote value.
+func reloadRemoteConfig(configPath string, settings map[string]interface{}, apply func(Config) error) error {
+	v := viper.New()
+	v.SetConfigFile(configPath)
+	setConfigDefaults(v)
+	if err := v.ReadInConfig(); err != nil {
+		return fmt.Errorf("failed to read %s: %v", configPath, err)
+	}
+	if err := v.MergeConfigMap(settings); err != nil {
+		return err
+	}
+	var config Config
+	if err := v.Unmarshal(&config); err != nil {
+		return err
+	}
+	return apply(config)
+}
+
+// changedSettings returns the keys whose values differ between before and
+// after, sorted. Nested maps and lists are compared element by element,
+// so a changed sink URL is reported as sinks.1.url rather than sinks.
+func changedSettings(before, after map[string]interface{}) []string {
+	var changed []string
+	diffSettings("", before, after, &changed)
+	sort.Strings(changed)
+	return changed
+}
+
+func diffSettings(key string, before, after interface{}, changed *[]string) {
+	join := func(k string) string {
+		if key == "" {
+			return k
+		}
+		return key + "." + k
+	}
+	switch a := after.(type) {
+	case map[string]interface{}:
+		if b, ok := before.(map[string]interface{}); ok {
+			for k, v := range a {
+				if _, ok := b[k]; !ok {
+					*changed = append(*changed, join(k))
+					continue
+				}
+				diffSettings(join(k), b[k], v, changed)
+			}
+			for k := range b {
+				if _, ok := a[k]; !ok {
+					*changed = append(*changed, join(k))
+				}
+			}
+			return
+		}
+	case []interface{}:
+		if b, ok := before.([]interface{}); ok && len(a) == len(b) {
+			for i := range a {
+				diffSettings(join(strconv.Itoa(i)), b[i], a[i], changed)
+			}
+			return
+		}
+	}
+	if !reflect.DeepEqual(before, after) {
+		*changed = append(*changed, key)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestChangedSettings(t *testing.T) {
+	before := map[string]interface{}{
+		"filter":      "size > 0",
+		"sample_rate": 1.0,
+		"sinks": []interface{}{
+			map[string]interface{}{"name": "console", "type": "stdout"},
+			map[string]interface{}{"name": "hook", "type": "webhook", "url": "http://a/"},
+		},
+		"debounce_by_ext": map[string]interface{}{"log": "5s"},
+		"removed":         true,
+	}
+	after := map[string]interface{}{
+		"filter":      "size > 0",
+		"sample_rate": 0.5,
+		"sinks": []interface{}{
+			map[string]interface{}{"name": "console", "type": "stdout"},
+			map[string]interface{}{"name": "hook", "type": "webhook", "url": "http://b/"},
+		},
+		"debounce_by_ext": map[string]interface{}{"log": "5s", "csv": "1s"},
+		"added":           "x",
+	}
+	want := []string{"added", "debounce_by_ext.csv", "removed", "sample_rate", "sinks.1.url"}
+	if got := changedSettings(before, after); !equalStrings(got, want) {
+		t.Errorf("changedSettings = %v, want %v", got, want)
+	}
+
+	// A list that changed length is reported as a whole
+	after["sinks"] = after["sinks"].([]interface{})[:1]
+	want = []string{"added", "debounce_by_ext.csv", "removed", "sample_rate", "sinks"}
+	if got := changedSettings(before, after); !equalStrings(got, want) {
+		t.Errorf("changedSettings = %v, want %v", got, want)
+	}
+
+	if got := changedSettings(before, before); len(got) != 0 {
+		t.Errorf("unchanged settings reported %v", got)
+	}
+}
+
+// Each refresh starts again from the local file, so a setting removed from
+// the remote value reverts to the local one, or to its default.
+func TestReloadRemoteConfigRevertsRemovedKeys(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "configuration.yaml")
+	if err := ioutil.WriteFile(configPath, []byte("filter: \"size > 0\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var got Config
+	apply := func(config Config) error {
+		got = config
+		return nil
+	}
+
+	remote := map[string]interface{}{"filter": "size > 1", "sample_rate": 0.5}
+	if err := reloadRemoteConfig(configPath, remote, apply); err != nil {
+		t.Fatal(err)
+	}
+	if got.Filter != "size > 1" || got.SampleRate != 0.5 {
+		t.Errorf("with remote values: filter %q, sample_rate %v", got.Filter, got.SampleRate)
+	}
+
+	if err := reloadRemoteConfig(configPath, map[string]interface{}{}, apply); err != nil {
+		t.Fatal(err)
+	}
+	if got.Filter != "size > 0" {
+		t.Errorf("filter = %q after its removal, want the local %q", got.Filter, "size > 0")
+	}
+	if got.SampleRate != 1 {
+		t.Errorf("sample_rate = %v after its removal, want the default 1", got.SampleRate)
+	}
+}
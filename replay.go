@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// replayStorage sends every record in the storage file to the sinks routes
+// selects for it. The storage file itself is not written. Records whose file has
+// since disappeared are still sent, since they describe what happened at
+// the time; they are counted in the summary.
+func replayStorage(config Config, sinks []Sink, routes *router) error {
+	if isStorageTemplate(config.StorageLocation) {
+		return fmt.Errorf("-replay-storage needs a single storage_location, not a template")
+	}
+	records, err := readRecords(config.StorageLocation, config.Format)
+	if err != nil {
+		return err
+	}
+
+	missing := 0
+	for _, fileData := range records {
+		if _, err := os.Lstat(fileData.Path); os.IsNotExist(err) {
+			debugf("Replaying %s, which no longer exists", fileData.Path)
+			missing++
+		}
+		dispatch(routes.sinksFor(fileData), fileData, nil)
+	}
+	log.Printf("Replayed %d records to %d sinks (%d files no longer exist)", len(records), len(sinks), missing)
+	return nil
+}
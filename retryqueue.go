@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	retryTick          = 5 * time.Second
+	retryInitialDelay  = 10 * time.Second
+	retryMaxDelay      = time.Hour
+	retryCompactWaste  = 1000
+	retryOpAdd         = "add"
+	retryOpDone        = "done"
+	retryScannerMaxLen = 16 * 1024 * 1024
+)
+
+// retryEntry is a record that a sink failed to accept.
+type retryEntry struct {
+	ID          uint64    `json:"id"`
+	Sink        string    `json:"sink"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	Record      FileData  `json:"record"`
+}
+
+// retryOp is one line of the queue file: an entry added (or updated after a
+// failed retry), or an entry delivered.
+type retryOp struct {
+	Op    string      `json:"op"`
+	Entry *retryEntry `json:"entry,omitempty"`
+	ID    uint64      `json:"id,omitempty"`
+}
+
+// retryQueue keeps records that failed to reach a sink in an append-only
+// file and retries them in the background with exponential backoff, so a
+// downstream outage heals itself, across restarts, once the sink is back.
+// The file is replayed on open and compacted to the live entries after
+// retry passes.
+type retryQueue struct {
+	path  string
+	sinks map[string]Sink
+
+	mu      sync.Mutex
+	entries map[uint64]*retryEntry
+	nextID  uint64
+	waste   int // lines in the file that no longer describe a live entry
+
+	done    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// openRetryQueue loads the queue at path and starts retrying it.
+func openRetryQueue(path string, sinks []Sink) (*retryQueue, error) {
+	q := &retryQueue{
+		path:    path,
+		sinks:   make(map[string]Sink, len(sinks)),
+		entries: make(map[uint64]*retryEntry),
+		done:    make(chan struct{}),
+	}
+	for _, sink := range sinks {
+		q.sinks[sink.Name()] = sink
+	}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	if len(q.entries) > 0 {
+		log.Printf("Retry queue holds %d undelivered records", len(q.entries))
+	}
+	q.stopped.Add(1)
+	go q.run()
+	return q, nil
+}
+
+// load replays the queue file.
+func (q *retryQueue) load() error {
+	f, err := os.Open(q.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open retry queue: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), retryScannerMaxLen)
+	for scanner.Scan() {
+		var op retryOp
+		if err := json.Unmarshal(scanner.Bytes(), &op); err != nil {
+			// A torn final line from a crash; everything before it is intact
+			log.Printf("Ignoring unreadable retry queue line: %v", err)
+			continue
+		}
+		switch {
+		case op.Op == retryOpAdd && op.Entry != nil:
+			if _, ok := q.entries[op.Entry.ID]; ok {
+				q.waste++
+			}
+			q.entries[op.Entry.ID] = op.Entry
+			if op.Entry.ID >= q.nextID {
+				q.nextID = op.Entry.ID + 1
+			}
+		case op.Op == retryOpDone:
+			delete(q.entries, op.ID)
+			q.waste += 2
+		}
+	}
+	return scanner.Err()
+}
+
+// enqueue records that sink failed to accept fileData.
+func (q *retryQueue) enqueue(sink string, fileData FileData) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entry := &retryEntry{
+		ID:          q.nextID,
+		Sink:        sink,
+		NextAttempt: time.Now().Add(retryInitialDelay),
+		Record:      fileData,
+	}
+	q.nextID++
+	q.entries[entry.ID] = entry
+	if err := q.appendOps(retryOp{Op: retryOpAdd, Entry: entry}); err != nil {
+		log.Printf("Failed to persist retry for %s to sink %s: %v", fileData.Path, sink, err)
+	}
+}
+
+// appendOps writes ops to the end of the queue file in one write.
+func (q *retryQueue) appendOps(ops ...retryOp) error {
+	var buf bytes.Buffer
+	for _, op := range ops {
+		line, err := json.Marshal(op)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	f, err := os.OpenFile(q.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (q *retryQueue) run() {
+	defer q.stopped.Done()
+	ticker := time.NewTicker(retryTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			q.retryDue(now)
+		case <-q.done:
+			return
+		}
+	}
+}
+
+// retryDue resends every entry whose backoff has expired.
+func (q *retryQueue) retryDue(now time.Time) {
+	q.mu.Lock()
+	var due []retryEntry
+	for _, entry := range q.entries {
+		if !entry.NextAttempt.After(now) {
+			due = append(due, *entry)
+		}
+	}
+	q.mu.Unlock()
+	if len(due) == 0 {
+		return
+	}
+
+	var ops []retryOp
+	for _, entry := range due {
+		sink, ok := q.sinks[entry.Sink]
+		if !ok {
+			log.Printf("Dropping retry for %s: sink %s is no longer configured", entry.Record.Path, entry.Sink)
+			ops = append(ops, retryOp{Op: retryOpDone, ID: entry.ID})
+			continue
+		}
+		if err := sink.Send(entry.Record); err != nil {
+			entry.Attempts++
+			entry.NextAttempt = now.Add(retryDelay(entry.Attempts))
+			debugf("Retry %d of %s to sink %s failed: %v", entry.Attempts, entry.Record.Path, entry.Sink, err)
+			updated := entry
+			ops = append(ops, retryOp{Op: retryOpAdd, Entry: &updated})
+			continue
+		}
+		ops = append(ops, retryOp{Op: retryOpDone, ID: entry.ID})
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, op := range ops {
+		if op.Op == retryOpDone {
+			delete(q.entries, op.ID)
+			q.waste += 2
+		} else if _, ok := q.entries[op.Entry.ID]; ok {
+			q.entries[op.Entry.ID] = op.Entry
+			q.waste++
+		}
+	}
+	if q.waste >= retryCompactWaste || len(q.entries) == 0 {
+		if err := q.compactLocked(); err != nil {
+			log.Printf("Failed to compact retry queue: %v", err)
+		}
+		return
+	}
+	if err := q.appendOps(ops...); err != nil {
+		log.Printf("Failed to update retry queue: %v", err)
+	}
+}
+
+// retryDelay is the backoff after the given number of failed retries.
+func retryDelay(attempts int) time.Duration {
+	delay := retryInitialDelay
+	for i := 0; i < attempts && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	return delay
+}
+
+// compactLocked rewrites the queue file with one line per live entry.
+func (q *retryQueue) compactLocked() error {
+	var buf bytes.Buffer
+	for _, entry := range q.entries {
+		line, err := json.Marshal(retryOp{Op: retryOpAdd, Entry: entry})
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if err := writeFileAtomic(q.path, buf.Bytes()); err != nil {
+		return err
+	}
+	q.waste = 0
+	return nil
+}
+
+// close stops retrying. Undelivered entries stay in the file for the next
+// run.
+func (q *retryQueue) close() {
+	close(q.done)
+	q.stopped.Wait()
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+// defaultMaxOpenFiles has no descriptor limit to query on this platform.
+func defaultMaxOpenFiles() int {
+	return fallbackMaxOpenFiles
+}
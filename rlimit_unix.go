@@ -0,0 +1,22 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// defaultMaxOpenFiles allows half of the process file descriptor limit,
+// leaving the rest for the watcher, logging and the Go runtime.
+func defaultMaxOpenFiles() int {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return fallbackMaxOpenFiles
+	}
+	limit := uint64(rl.Cur) / 2
+	if limit < 1 {
+		return 1
+	}
+	if limit > maxDefaultOpenFiles {
+		return maxDefaultOpenFiles
+	}
+	return int(limit)
+}
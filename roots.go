@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// watchRoots returns the directories configured for watching: the
+// target_directory plus every directory matched by the target_directories
+// entries, which may be glob patterns. Roots are cleaned and deduplicated.
+func watchRoots(config Config) ([]string, error) {
+	var roots []string
+	seen := make(map[string]bool)
+	addRoot := func(dir string) {
+		dir = filepath.Clean(dir)
+		if !seen[pathKey(dir)] {
+			seen[pathKey(dir)] = true
+			roots = append(roots, dir)
+		}
+	}
+
+	if config.TargetDirectory != "" {
+		addRoot(config.TargetDirectory)
+	}
+	for _, pattern := range config.TargetDirectories {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, FieldError{Field: "target_directories", Message: fmt.Sprintf("pattern %q: %v", pattern, err)}
+		}
+		found := false
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.IsDir() {
+				addRoot(match)
+				found = true
+			}
+		}
+		if !found {
+			return nil, FieldError{Field: "target_directories", Message: fmt.Sprintf("%q matches no directories", pattern)}
+		}
+	}
+	if len(roots) == 0 {
+		return nil, FieldError{Field: "target_directory", Message: "target_directory or target_directories must be set"}
+	}
+	return roots, nil
+}
+
+// pruneNestedRoots drops roots that are already covered by an ancestor
+// root, logging a warning for each. Without recursion, or when max_depth
+// limits how far an ancestor reaches, a nested root watches directories its
+// ancestor does not and is kept; walks then leave its directories to it
+// (see nestedRoot).
+func pruneNestedRoots(roots []string, recursive bool, maxDepth int) []string {
+	if !recursive || maxDepth != 0 {
+		return roots
+	}
+	var kept []string
+	for _, root := range roots {
+		if ancestor := rootFor(otherRoots(roots, root), root); ancestor != "" {
+			log.Printf("Warning: watch root %s is inside %s and is already watched recursively; ignoring it", root, ancestor)
+			continue
+		}
+		kept = append(kept, root)
+	}
+	return kept
+}
+
+// nestedRoot reports whether dir, met while walking root, belongs to a
+// root nested inside it. That root walks dir itself, and reaches at least
+// as deep as root would, so walking it from root too reports its files
+// twice.
+func nestedRoot(roots []string, root, dir string) bool {
+	return rootFor(roots, dir) != root
+}
+
+// otherRoots returns roots without root.
+func otherRoots(roots []string, root string) []string {
+	others := make([]string, 0, len(roots))
+	for _, r := range roots {
+		if r != root {
+			others = append(others, r)
+		}
+	}
+	return others
+}
+
+// rootFor returns the watch root path falls under. When roots are nested
+// the deepest one wins, so the returned root is the closest configured
+// directory.
+func rootFor(roots []string, path string) string {
+	best := ""
+	key := pathKey(path)
+	for _, root := range roots {
+		rootKey := pathKey(root)
+		if key == rootKey || strings.HasPrefix(key, rootKey+string(filepath.Separator)) {
+			if len(root) > len(best) {
+				best = root
+			}
+		}
+	}
+	return best
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Overlapping roots record each file once, whether the nested root is
+// pruned (no max_depth) or kept (max_depth set).
+func TestOverlappingRootsRecordOnce(t *testing.T) {
+	parent := t.TempDir()
+	child := filepath.Join(parent, "child")
+	mkdirs(t, parent, ".", "child", "child/sub")
+	files := []string{
+		filepath.Join(child, "file.txt"),
+		filepath.Join(child, "sub", "file.txt"),
+		filepath.Join(parent, "file.txt"),
+	}
+
+	for _, tt := range []struct {
+		maxDepth  int
+		roots     []string
+		childRoot string // watch_root of the files below child
+	}{
+		{0, []string{parent}, parent},
+		{3, []string{parent, child}, child},
+	} {
+		maxDepth := tt.maxDepth
+		config := Config{Recursive: true, MaxDepth: maxDepth}
+		roots := pruneNestedRoots([]string{parent, child}, config.Recursive, config.MaxDepth)
+		if !equalStrings(roots, tt.roots) {
+			t.Fatalf("max_depth %d: roots = %v, want %v", maxDepth, roots, tt.roots)
+		}
+		globs, err := newGlobFilter(roots, nil, nil, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		queue := make(chan fileEvent, 10)
+		newStartupScan(roots, config, nil, globs, queue).run()
+		close(queue)
+		var scanned []string
+		for ev := range queue {
+			scanned = append(scanned, ev.Path)
+		}
+		sort.Strings(scanned)
+		if !equalStrings(scanned, files) {
+			t.Errorf("max_depth %d: scanned %v, want %v", maxDepth, scanned, files)
+		}
+
+		p := newPoller(roots, config, newSemaphore(1))
+		p.scan(nil)
+		for _, path := range files {
+			appendTo(t, path, []byte("x"))
+		}
+		var changed []string
+		p.scan(func(event fsnotify.Event) {
+			changed = append(changed, event.Name)
+		})
+		sort.Strings(changed)
+		if !equalStrings(changed, files) {
+			t.Errorf("max_depth %d: poll reported %v, want %v", maxDepth, changed, files)
+		}
+
+		for _, path := range files[:2] {
+			if got := rootFor(roots, path); got != tt.childRoot {
+				t.Errorf("max_depth %d: %s recorded under %s, want %s", maxDepth, path, got, tt.childRoot)
+			}
+		}
+	}
+}
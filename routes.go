@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+)
+
+// RouteConfig sends the records it matches to the named sinks only. A
+// route matches when every matcher it sets matches: the file's extension
+// is one of Extensions, its name matches Glob, and Filter, an expression
+// in the filter syntax, is true.
+type RouteConfig struct {
+	Extensions []string `mapstructure:"extensions"`
+	Glob       string   `mapstructure:"glob"`
+	Filter     string   `mapstructure:"filter"`
+	Sinks      []string `mapstructure:"sinks"`
+}
+
+// route is a compiled RouteConfig.
+type route struct {
+	exts   map[string]bool
+	glob   string
+	filter *recordFilter
+	sinks  []Sink
+}
+
+// router picks the sinks each record is sent to: those of the first route
+// it matches, or the default sinks when it matches none.
+type router struct {
+	routes   []route
+	defaults []Sink
+}
+
+// validateRoute reports what is wrong with cfg, or nil. names holds the
+// configured sink names.
+func validateRoute(cfg RouteConfig, names map[string]bool) error {
+	if len(cfg.Extensions) == 0 && cfg.Glob == "" && cfg.Filter == "" {
+		return fmt.Errorf("route to %v needs extensions, a glob or a filter", cfg.Sinks)
+	}
+	if _, err := filepath.Match(cfg.Glob, ""); err != nil {
+		return fmt.Errorf("route glob %q: %v", cfg.Glob, err)
+	}
+	if _, err := compileFilter(cfg.Filter); err != nil {
+		return fmt.Errorf("route filter %q: %v", cfg.Filter, err)
+	}
+	for _, name := range cfg.Sinks {
+		if !names[name] {
+			return fmt.Errorf("route to unknown sink %q", name)
+		}
+	}
+	return nil
+}
+
+// newRouter compiles routes over sinks. Without default_sinks, records
+// matching no route go to every sink, as they do when no routes are set.
+func newRouter(cfgs []RouteConfig, defaults []string, sinks []Sink) (*router, error) {
+	byName := make(map[string]Sink, len(sinks))
+	names := make(map[string]bool, len(sinks))
+	for _, sink := range sinks {
+		byName[sink.Name()] = sink
+		names[sink.Name()] = true
+	}
+	pick := func(list []string) []Sink {
+		picked := make([]Sink, 0, len(list))
+		for _, name := range list {
+			picked = append(picked, byName[name])
+		}
+		return picked
+	}
+
+	r := &router{defaults: sinks}
+	if len(defaults) > 0 {
+		for _, name := range defaults {
+			if !names[name] {
+				return nil, fmt.Errorf("default sink %q is not configured", name)
+			}
+		}
+		r.defaults = pick(defaults)
+	}
+	for _, cfg := range cfgs {
+		if err := validateRoute(cfg, names); err != nil {
+			return nil, err
+		}
+		filter, _ := compileFilter(cfg.Filter)
+		rt := route{glob: cfg.Glob, filter: filter, sinks: pick(cfg.Sinks)}
+		if len(cfg.Extensions) > 0 {
+			rt.exts = make(map[string]bool, len(cfg.Extensions))
+			for _, ext := range cfg.Extensions {
+				rt.exts[normalizeExt(ext)] = true
+			}
+		}
+		r.routes = append(r.routes, rt)
+	}
+	return r, nil
+}
+
+// sinksFor returns the sinks fileData is sent to.
+func (r *router) sinksFor(fileData FileData) []Sink {
+	for _, rt := range r.routes {
+		if rt.match(fileData) {
+			return rt.sinks
+		}
+	}
+	return r.defaults
+}
+
+// match reports whether fileData matches every matcher of the route. A
+// filter that fails to evaluate is logged and does not match.
+func (rt route) match(fileData FileData) bool {
+	if rt.exts != nil && !rt.exts[normalizeExt(filepath.Ext(fileData.Path))] {
+		return false
+	}
+	if rt.glob != "" {
+		if ok, _ := filepath.Match(rt.glob, filepath.Base(fileData.Path)); !ok {
+			return false
+		}
+	}
+	if rt.filter != nil {
+		ok, err := rt.filter.match(fileData)
+		if err != nil {
+			log.Printf("Failed to evaluate route filter for %s: %v", fileData.Path, err)
+			return false
+		}
+		return ok
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+)
+
+// Sampling counters, reported by the stats logger.
+var (
+	sampledIn  uint64
+	sampledOut uint64
+)
+
+// sampler keeps a fraction of events when sample_rate is below 1. With
+// perPath the decision is a hash of the path, so a given file is always
+// either kept or dropped; otherwise each event is drawn independently.
+type sampler struct {
+	rate    float64
+	perPath bool
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// newSampler returns nil, keeping everything, for rates of 1 and above.
+func newSampler(rate float64, perPath bool, seed int64) *sampler {
+	if rate >= 1 {
+		return nil
+	}
+	return &sampler{rate: rate, perPath: perPath, rnd: rand.New(rand.NewSource(seed))}
+}
+
+// keep reports whether the event for path is sampled in.
+func (s *sampler) keep(path string) bool {
+	var x float64
+	if s.perPath {
+		h := fnv.New64a()
+		h.Write([]byte(pathKey(path)))
+		x = float64(h.Sum64()>>11) / (1 << 53)
+	} else {
+		s.mu.Lock()
+		x = s.rnd.Float64()
+		s.mu.Unlock()
+	}
+	if x < s.rate {
+		atomic.AddUint64(&sampledIn, 1)
+		return true
+	}
+	atomic.AddUint64(&sampledOut, 1)
+	return false
+}
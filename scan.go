@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// eventScan marks a file recorded by the startup scan; it is not
+// selectable in record_events.
+const eventScan = "scan"
+
+// errScanStopped ends the walk when the scan is cancelled.
+var errScanStopped = errors.New("scan stopped")
+
+// startupScan records the files already present in the watch roots. One
+// goroutine walks the tree and queues what it finds for the workers, so
+// the scan is bounded by concurrency_level and max_open_files like live
+// events. The walk waits whenever the queue is full, so a tree far larger
+// than the queue is fed in as fast as the workers keep up.
+type startupScan struct {
+	roots     []string
+	recursive bool
+	maxDepth  int
+	markers   string
+	exclude   []*regexp.Regexp
+	globs     *globFilter
+	queue     chan<- fileEvent
+
+	stop chan struct{}
+	once sync.Once
+}
+
+func newStartupScan(roots []string, config Config, exclude []*regexp.Regexp, globs *globFilter, queue chan<- fileEvent) *startupScan {
+	return &startupScan{
+		roots:     roots,
+		recursive: config.Recursive,
+		maxDepth:  config.MaxDepth,
+		markers:   config.CompletionMarkerSuffix,
+		exclude:   exclude,
+		globs:     globs,
+		queue:     queue,
+		stop:      make(chan struct{}),
+	}
+}
+
+// run scans every root and returns once all files found have been queued,
+// or soon after cancel is called.
+func (s *startupScan) run() {
+	start := time.Now()
+	var count int
+
+	for _, root := range s.roots {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				log.Printf("Failed to scan %s: %v", path, err)
+				return nil
+			}
+			if matchesAny(s.exclude, path) || s.globs.excluded(path) {
+				if info.IsDir() && path != root {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.IsDir() {
+				if path != root && (!s.recursive || !withinDepth(root, path, s.maxDepth) || nestedRoot(s.roots, root, path)) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !s.globs.included(path) {
+				return nil
+			}
+			ev := fileEvent{Path: path, Event: eventScan}
+			if s.markers != "" {
+				var ok bool
+				if ev, ok = markerEvent(fsnotify.Event{Name: path, Op: fsnotify.Create}, s.markers); !ok {
+					return nil
+				}
+			}
+			select {
+			case s.queue <- ev:
+				count++
+				return nil
+			case <-s.stop:
+				return errScanStopped
+			}
+		})
+		if err == errScanStopped {
+			break
+		}
+	}
+	log.Printf("Startup scan queued %d files in %v", count, time.Since(start).Round(time.Millisecond))
+}
+
+// cancel stops the scan from queuing further files.
+func (s *startupScan) cancel() {
+	s.once.Do(func() { close(s.stop) })
+}
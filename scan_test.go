@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// BenchmarkStartupScan walks a tree of 10,000 files into a queue drained
+// by concurrency_level workers, as the startup scan runs in main.
+func BenchmarkStartupScan(b *testing.B) {
+	const dirs, filesPerDir, workers = 100, 100, 5
+	root := b.TempDir()
+	for d := 0; d < dirs; d++ {
+		dir := filepath.Join(root, fmt.Sprintf("d%03d", d))
+		if err := os.Mkdir(dir, 0755); err != nil {
+			b.Fatal(err)
+		}
+		for f := 0; f < filesPerDir; f++ {
+			if err := ioutil.WriteFile(filepath.Join(dir, fmt.Sprintf("f%03d.txt", f)), nil, 0644); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	globs, err := newGlobFilter([]string{root}, nil, nil, "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	config := Config{Recursive: true}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		queue := make(chan fileEvent, workers)
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		seen := 0
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range queue {
+					mu.Lock()
+					seen++
+					mu.Unlock()
+				}
+			}()
+		}
+		newStartupScan([]string{root}, config, nil, globs, queue).run()
+		close(queue)
+		wg.Wait()
+		if seen != dirs*filesPerDir {
+			b.Fatalf("scan queued %d files, want %d", seen, dirs*filesPerDir)
+		}
+	}
+}
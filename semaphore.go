@@ -0,0 +1,23 @@
+package main
+
+const (
+	// fallbackMaxOpenFiles is used when the descriptor limit is unknown.
+	fallbackMaxOpenFiles = 256
+	// maxDefaultOpenFiles caps the default when the limit is very high or unlimited.
+	maxDefaultOpenFiles = 4096
+)
+
+// semaphore bounds how many goroutines may hold a resource at once.
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	return make(semaphore, n)
+}
+
+func (s semaphore) acquire() {
+	s <- struct{}{}
+}
+
+func (s semaphore) release() {
+	<-s
+}
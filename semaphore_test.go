@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSemaphoreBoundsOpenFiles(t *testing.T) {
+	const limit, workers = 4, 64
+	dir := t.TempDir()
+	for i := 0; i < workers; i++ {
+		name := filepath.Join(dir, fmt.Sprintf("f%d.txt", i))
+		if err := ioutil.WriteFile(name, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	openFiles := newSemaphore(limit)
+	var inFlight, peak int32
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			openFiles.acquire()
+			defer openFiles.release()
+
+			f, err := os.Open(filepath.Join(dir, fmt.Sprintf("f%d.txt", i)))
+			if err != nil {
+				errs <- err
+				return
+			}
+			n := atomic.AddInt32(&inFlight, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&inFlight, -1)
+			f.Close()
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("open: %v", err)
+	}
+	if peak > limit {
+		t.Errorf("%d files open at once, want at most %d", peak, limit)
+	}
+	if peak < 2 {
+		t.Errorf("peak of %d open files, want workers to overlap", peak)
+	}
+}
@@ -0,0 +1,55 @@
+//go:build !windows
+
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+)
+
+// serviceNotifier reports the watcher's lifecycle to systemd through the
+// sd_notify protocol when service_mode is set and systemd provided a
+// notification socket.
+type serviceNotifier struct {
+	socket string
+}
+
+// startService prepares systemd notifications. shutdown is not needed:
+// systemd stops the service with SIGTERM, which is already handled.
+func startService(config Config, shutdown func(reason string)) *serviceNotifier {
+	if !config.ServiceMode {
+		return &serviceNotifier{}
+	}
+	return &serviceNotifier{socket: os.Getenv("NOTIFY_SOCKET")}
+}
+
+// ready reports that the watcher is up.
+func (n *serviceNotifier) ready() { n.notify("READY=1") }
+
+// stopping reports that shutdown has begun.
+func (n *serviceNotifier) stopping() { n.notify("STOPPING=1") }
+
+// stopped has nothing to report: systemd sees the process exit.
+func (n *serviceNotifier) stopped() {}
+
+// notify sends state to the notification socket, if there is one. A
+// leading @ names a socket in the abstract namespace.
+func (n *serviceNotifier) notify(state string) {
+	if n.socket == "" {
+		return
+	}
+	addr := &net.UnixAddr{Name: n.socket, Net: "unixgram"}
+	if addr.Name[0] == '@' {
+		addr.Name = "\x00" + addr.Name[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		log.Printf("Failed to notify systemd: %v", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Printf("Failed to notify systemd: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+//go:build windows
+
+package main
+
+import (
+	"log"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// serviceNotifier reports the watcher's lifecycle to the Windows service
+// control manager when the process runs as a service.
+type serviceNotifier struct {
+	readyCh   chan struct{}
+	stoppedCh chan struct{}
+	runDone   chan struct{} // nil unless running as a service
+}
+
+// startService hands the process to the service control manager when
+// service_mode is set and it was started as a service. Stop and shutdown
+// requests call shutdown. Outside a service it does nothing.
+func startService(config Config, shutdown func(reason string)) *serviceNotifier {
+	n := &serviceNotifier{readyCh: make(chan struct{}), stoppedCh: make(chan struct{})}
+	if !config.ServiceMode {
+		return n
+	}
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		log.Printf("Failed to detect the service environment: %v", err)
+		return n
+	}
+	if !isService {
+		return n
+	}
+	n.runDone = make(chan struct{})
+	go func() {
+		defer close(n.runDone)
+		if err := svc.Run(config.ServiceName, &serviceHandler{n: n, shutdown: shutdown}); err != nil {
+			log.Printf("Service %s failed: %v", config.ServiceName, err)
+		}
+	}()
+	return n
+}
+
+// ready reports that the watcher is up.
+func (n *serviceNotifier) ready() { close(n.readyCh) }
+
+// stopping reports that shutdown has begun.
+func (n *serviceNotifier) stopping() {}
+
+// stopped reports that everything has been written and waits for the
+// service control manager to mark the service stopped.
+func (n *serviceNotifier) stopped() {
+	close(n.stoppedCh)
+	if n.runDone != nil {
+		<-n.runDone
+	}
+}
+
+type serviceHandler struct {
+	n        *serviceNotifier
+	shutdown func(reason string)
+}
+
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	const accepts = svc.AcceptStop | svc.AcceptShutdown
+	status <- svc.Status{State: svc.StartPending}
+	select {
+	case <-h.n.readyCh:
+	case <-h.n.stoppedCh:
+		return false, 0
+	}
+	status <- svc.Status{State: svc.Running, Accepts: accepts}
+	for {
+		select {
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				h.shutdown("Service stop requested")
+			}
+		case <-h.n.stoppedCh:
+			status <- svc.Status{State: svc.Stopped}
+			return false, 0
+		}
+	}
+}
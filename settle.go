@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// settler holds newly created files back for settle_delay and drops those
+// that no longer exist when it expires, such as the temporary files atomic
+// writers create and immediately rename or delete. Other events pass
+// straight through.
+type settler struct {
+	delay time.Duration
+	next  func(fileEvent)
+
+	pending sync.WaitGroup
+}
+
+func newSettler(delay time.Duration, next func(fileEvent)) *settler {
+	return &settler{delay: delay, next: next}
+}
+
+func (s *settler) add(ev fileEvent) {
+	if s.delay == 0 || ev.Event != eventCreate {
+		s.next(ev)
+		return
+	}
+	s.pending.Add(1)
+	time.AfterFunc(s.delay, func() {
+		defer s.pending.Done()
+		if _, err := os.Stat(ev.Path); err != nil {
+			debugf("Skipping %s: gone before settle_delay elapsed", ev.Path)
+			return
+		}
+		s.next(ev)
+	})
+}
+
+// wait blocks until every held-back event has been passed on or dropped.
+func (s *settler) wait() {
+	s.pending.Wait()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// sidecarSuffix names the sidecar file holding tags for the file it is
+// appended to.
+const sidecarSuffix = ".meta.json"
+
+// isSidecar reports whether path is a sidecar. Sidecars describe other
+// files and are not recorded themselves.
+func isSidecar(path string) bool {
+	return strings.HasSuffix(pathKey(path), sidecarSuffix)
+}
+
+// readSidecarTags loads the tags from path's sidecar, path + ".meta.json",
+// which must be a regular file holding a JSON object of strings. It
+// returns nil tags and no error when there is no sidecar. Anything but a
+// regular file is refused before it is opened, since opening a FIFO
+// blocks until a writer appears.
+func readSidecarTags(path string) (map[string]string, error) {
+	sidecar := path + sidecarSuffix
+	info, err := os.Stat(sidecar)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", sidecar)
+	}
+	f, err := openWatched(sidecar)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	var tags map[string]string
+	if err := json.Unmarshal(data, &tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
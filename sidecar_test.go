@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadSidecarTags(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path+sidecarSuffix, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tags, err := readSidecarTags(write("tagged.csv", `{"owner": "etl", "batch": "42"}`))
+	if err != nil || !reflect.DeepEqual(tags, map[string]string{"owner": "etl", "batch": "42"}) {
+		t.Errorf("tagged: tags = %v, err = %v", tags, err)
+	}
+	if tags, err := readSidecarTags(filepath.Join(dir, "plain.csv")); tags != nil || err != nil {
+		t.Errorf("no sidecar: tags = %v, err = %v", tags, err)
+	}
+	if _, err := readSidecarTags(write("broken.csv", `{"owner": 1}`)); err == nil {
+		t.Error("malformed sidecar: no error")
+	}
+	dirSidecar := filepath.Join(dir, "dir.csv")
+	if err := os.Mkdir(dirSidecar+sidecarSuffix, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readSidecarTags(dirSidecar); err == nil {
+		t.Error("directory sidecar: no error")
+	}
+}
+
+// A data file is recorded with its sidecar's tags; the sidecar itself is
+// not recorded.
+func TestProcessFileSkipsSidecars(t *testing.T) {
+	dir := t.TempDir()
+	watched := filepath.Join(dir, "watched")
+	if err := os.Mkdir(watched, 0755); err != nil {
+		t.Fatal(err)
+	}
+	data := filepath.Join(watched, "data.csv")
+	if err := ioutil.WriteFile(data, []byte("a,b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(data+sidecarSuffix, []byte(`{"owner": "etl"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	storage := filepath.Join(dir, "events.ndjson")
+	p := newTestProcessor(t, Config{StorageLocation: storage, Format: formatNDJSON}, []string{watched})
+	p.processFile(fileEvent{Path: data + sidecarSuffix, Event: eventCreate})
+	p.processFile(fileEvent{Path: data, Event: eventCreate})
+	p.rec.close()
+
+	records, err := readRecords(storage, formatNDJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Path != data || records[0].Tags["owner"] != "etl" {
+		t.Errorf("records = %+v, want only %s tagged owner=etl", records, data)
+	}
+}
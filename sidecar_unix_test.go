@@ -0,0 +1,33 @@
+//go:build unix
+
+package main
+
+import (
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// A FIFO named like a sidecar must not be opened: the open would block
+// until a writer appears.
+func TestReadSidecarTagsRefusesFIFO(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.csv")
+	if err := syscall.Mkfifo(path+sidecarSuffix, 0644); err != nil {
+		t.Skipf("mkfifo: %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := readSidecarTags(path)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("FIFO sidecar: no error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("readSidecarTags blocked on a FIFO")
+	}
+}
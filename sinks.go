@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Sink types accepted in the sinks setting.
+const (
+	sinkStdout  = "stdout"
+	sinkWebhook = "webhook"
+)
+
+// defaultSinkTimeout bounds a single webhook request.
+const defaultSinkTimeout = 10 * time.Second
+
+// SinkConfig configures one destination records are sent to in addition to
+// the storage file. Format picks how each sink encodes records,
+// independently of the storage format.
+type SinkConfig struct {
+	Name      string        `mapstructure:"name"`
+	Type      string        `mapstructure:"type"`
+	Format    string        `mapstructure:"format"`
+	EventType string        `mapstructure:"event_type"`
+	URL       string        `mapstructure:"url"`
+	Timeout   time.Duration `mapstructure:"timeout"`
+}
+
+// Sink receives every recorded event and encodes it in its own format.
+type Sink interface {
+	Name() string
+	Format() string
+	Send(fileData FileData) error
+}
+
+// defaultSinkFormat is the format a sink type uses when none is set.
+func defaultSinkFormat(sinkType string) string {
+	if sinkType == sinkStdout {
+		return sinkFormatNDJSON
+	}
+	return sinkFormatJSON
+}
+
+// validateSinkConfig reports what is wrong with cfg, or nil.
+func validateSinkConfig(cfg SinkConfig) error {
+	if cfg.Name == "" {
+		return fmt.Errorf("sink of type %q has no name", cfg.Type)
+	}
+	switch cfg.Type {
+	case sinkStdout:
+	case sinkWebhook:
+		if cfg.URL == "" {
+			return fmt.Errorf("sink %q: webhook needs a url", cfg.Name)
+		}
+	default:
+		return fmt.Errorf("sink %q: unknown type %q", cfg.Name, cfg.Type)
+	}
+	if _, err := newEncoding(cfg.Format, defaultSinkFormat(cfg.Type), cfg.EventType); err != nil {
+		return fmt.Errorf("sink %q: %v", cfg.Name, err)
+	}
+	if cfg.Timeout < 0 {
+		return fmt.Errorf("sink %q: timeout must not be negative", cfg.Name)
+	}
+	return nil
+}
+
+// newSinks builds the configured sinks.
+func newSinks(cfgs []SinkConfig) ([]Sink, error) {
+	sinks := make([]Sink, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		if err := validateSinkConfig(cfg); err != nil {
+			return nil, err
+		}
+		enc, _ := newEncoding(cfg.Format, defaultSinkFormat(cfg.Type), cfg.EventType)
+		switch cfg.Type {
+		case sinkStdout:
+			sinks = append(sinks, &stdoutSink{name: cfg.Name, enc: enc})
+		case sinkWebhook:
+			timeout := cfg.Timeout
+			if timeout == 0 {
+				timeout = defaultSinkTimeout
+			}
+			sinks = append(sinks, &webhookSink{
+				name:   cfg.Name,
+				enc:    enc,
+				url:    cfg.URL,
+				client: &http.Client{Timeout: timeout},
+			})
+		}
+	}
+	return sinks, nil
+}
+
+// dispatch sends fileData to every sink, logging failures and handing
+// them to retry when it is not nil.
+func dispatch(sinks []Sink, fileData FileData, retry *retryQueue) {
+	for _, sink := range sinks {
+		if err := sink.Send(fileData); err != nil {
+			log.Printf("Failed to send %s to sink %s: %v", fileData.Path, sink.Name(), err)
+			if retry != nil {
+				retry.enqueue(sink.Name(), fileData)
+			}
+		}
+	}
+}
+
+// stdoutSink prints each record on standard output, one per line for the
+// line-based formats.
+type stdoutSink struct {
+	name string
+	enc  sinkEncoding
+	mu   sync.Mutex
+}
+
+func (s *stdoutSink) Name() string   { return s.name }
+func (s *stdoutSink) Format() string { return s.enc.format }
+
+func (s *stdoutSink) Send(fileData FileData) error {
+	line, err := s.enc.encode(fileData)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasSuffix(line, []byte("\n")) {
+		line = append(line, '\n')
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = os.Stdout.Write(line)
+	return err
+}
+
+// webhookSink POSTs each encoded record to a URL.
+type webhookSink struct {
+	name   string
+	enc    sinkEncoding
+	url    string
+	client *http.Client
+}
+
+func (s *webhookSink) Name() string   { return s.name }
+func (s *webhookSink) Format() string { return s.enc.format }
+
+func (s *webhookSink) Send(fileData FileData) error {
+	body, err := s.enc.encode(fileData)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, s.enc.contentType, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
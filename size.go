@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// sizeAsString makes file sizes marshal as JSON strings. It is set once
+// from size_as_string before watching starts.
+var sizeAsString bool
+
+// fileSize is a size in bytes. It marshals as a JSON number, or as a string
+// when size_as_string is set so consumers that parse numbers as float64,
+// such as JavaScript, keep full precision above 2^53. Either form is
+// accepted when reading records back.
+type fileSize int64
+
+func (s fileSize) MarshalJSON() ([]byte, error) {
+	n := strconv.FormatInt(int64(s), 10)
+	if sizeAsString {
+		return []byte(strconv.Quote(n)), nil
+	}
+	return []byte(n), nil
+}
+
+func (s *fileSize) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var str string
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		}
+		data = []byte(str)
+	}
+	n, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return err
+	}
+	*s = fileSize(n)
+	return nil
+}
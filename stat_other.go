@@ -0,0 +1,21 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// fileIdentity is not available on this platform; both numbers are zero.
+func fileIdentity(info os.FileInfo) (inode, device uint64) {
+	return 0, 0
+}
+
+// fileOwner is not available on this platform, so owner filtering never
+// excludes anything.
+func fileOwner(info os.FileInfo) (uid int, ok bool) {
+	return 0, false
+}
+
+// fileLinks is not available on this platform; it returns zero.
+func fileLinks(info os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// statsLogger logs throughput at each tick of stats_interval.
+type statsLogger struct {
+	queue   chan fileEvent
+	workers int
+
+	lastTotal uint64
+	lastTick  time.Time
+}
+
+func newStatsLogger(queue chan fileEvent, workers int) *statsLogger {
+	return &statsLogger{queue: queue, workers: workers, lastTick: time.Now()}
+}
+
+// tick logs events per second since the previous tick, the total recorded
+// so far, the queue depth, the worker count and failed exec_command runs,
+// plus how many events sampling kept and dropped when sample_rate is set.
+func (s *statsLogger) tick(now time.Time) {
+	total := atomic.LoadUint64(&eventSeq)
+	rate := float64(total-s.lastTotal) / now.Sub(s.lastTick).Seconds()
+	line := fmt.Sprintf("Stats: %.1f events/sec, %d recorded, %d queued, %d workers, %d exec failures",
+		rate, total, len(s.queue), s.workers, atomic.LoadUint64(&execFailures))
+	if in, out := atomic.LoadUint64(&sampledIn), atomic.LoadUint64(&sampledOut); in+out > 0 {
+		line += fmt.Sprintf(", sampled %d of %d (%.1f%%)", in, in+out, 100*float64(in)/float64(in+out))
+	}
+	log.Print(line)
+	s.lastTotal, s.lastTick = total, now
+}
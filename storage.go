@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Storage formats accepted by the format setting.
+const (
+	formatJSON   = "json"
+	formatNDJSON = "ndjson"
+	formatCSV    = "csv"
+)
+
+// appendRecords adds records to the storage file in the given format.
+// Records are FileData or DirAggregate values.
+func appendRecords(storageLocation, format string, records []interface{}) error {
+	switch format {
+	case formatNDJSON:
+		return appendNDJSON(storageLocation, records)
+	case formatCSV:
+		return appendCSV(storageLocation, records)
+	default:
+		return appendJSON(storageLocation, records)
+	}
+}
+
+// readRecords loads every FileData record from the storage file, skipping
+// aggregate records. A missing file holds no records.
+func readRecords(storageLocation, format string) ([]FileData, error) {
+	if _, err := os.Stat(storageLocation); os.IsNotExist(err) {
+		return nil, nil
+	}
+	switch format {
+	case formatNDJSON:
+		return readNDJSON(storageLocation)
+	case formatCSV:
+		return readCSV(storageLocation)
+	default:
+		data, err := ioutil.ReadFile(storageLocation)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read storage file: %v", err)
+		}
+		var entries []json.RawMessage
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal storage file: %v", err)
+		}
+		var fileDataList []FileData
+		for _, entry := range entries {
+			fileData, ok, err := decodeFileData(entry)
+			if err != nil {
+				return nil, fmt.Errorf("failed to unmarshal storage file: %v", err)
+			}
+			if ok {
+				fileDataList = append(fileDataList, fileData)
+			}
+		}
+		return fileDataList, nil
+	}
+}
+
+// decodeFileData parses one stored record, reporting false for records of
+// another type.
+func decodeFileData(data []byte) (FileData, bool, error) {
+	var header struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return FileData{}, false, err
+	}
+	if header.Type == recordTypeAggregate {
+		return FileData{}, false, nil
+	}
+	var fileData FileData
+	if err := json.Unmarshal(data, &fileData); err != nil {
+		return FileData{}, false, err
+	}
+	return fileData, true, nil
+}
+
+// readNDJSON parses one record per non-empty line.
+func readNDJSON(storageLocation string) ([]FileData, error) {
+	f, err := os.Open(storageLocation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read storage file: %v", err)
+	}
+	defer f.Close()
+
+	var records []FileData
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		fileData, ok, err := decodeFileData(scanner.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal storage file line %d: %v", line, err)
+		}
+		if ok {
+			records = append(records, fileData)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read storage file: %v", err)
+	}
+	return records, nil
+}
+
+// appendJSON rewrites the storage file as an indented JSON array with
+// records added at the end. The new contents are written to a temporary
+// file and renamed over the old one, so a crash never leaves a truncated
+// array behind.
+func appendJSON(storageLocation string, records []interface{}) error {
+	// Read existing data, whatever the record type
+	var entries []json.RawMessage
+	if _, err := os.Stat(storageLocation); err == nil {
+		data, err := ioutil.ReadFile(storageLocation)
+		if err != nil {
+			return fmt.Errorf("failed to read storage file: %v", err)
+		}
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return fmt.Errorf("failed to unmarshal storage file: %v", err)
+		}
+	}
+
+	// Update file data
+	for _, record := range records {
+		entry, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to marshal data: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	// Write updated data
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal data: %v", err)
+	}
+	if err := writeFileAtomic(storageLocation, data); err != nil {
+		return fmt.Errorf("failed to write storage file: %v", err)
+	}
+	return nil
+}
+
+// appendNDJSON appends each record as one JSON line. All lines, each with
+// its newline, are written with a single Write on a file opened with
+// O_APPEND, so a reader tailing the file never observes a partial record.
+func appendNDJSON(storageLocation string, records []interface{}) error {
+	data, err := encodeNDJSON(records)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(storageLocation, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open storage file: %v", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write storage file: %v", err)
+	}
+	return f.Close()
+}
+
+// encodeNDJSON encodes records as JSON lines, each ending in a newline.
+func encodeNDJSON(records []interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal data: %v", err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// writeFileAtomic replaces path with data via a temporary file in the same
+// directory.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestProcessor returns a processor recording into config's storage
+// with no sinks, as main would build it. Settings viper defaults in main
+// get the same defaults here.
+func newTestProcessor(t testing.TB, config Config, roots []string) *processor {
+	t.Helper()
+	config.FollowSymlinks = true
+	if config.SampleRate == 0 {
+		config.SampleRate = 1
+	}
+	openFiles := newSemaphore(16)
+	live, err := newLiveSettings(config, nil, roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &processor{
+		config:    config,
+		roots:     roots,
+		rec:       newRecorder(config, openFiles),
+		checksums: newChecksummer(config.IncrementalChecksum),
+		paths:     newPathLocks(),
+		openFiles: openFiles,
+	}
+	p.live.Store(live)
+	return p
+}
+
+// tailLines reads f as it grows until stop is closed, passing each
+// complete line to check.
+func tailLines(f *os.File, stop <-chan struct{}, check func([]byte)) {
+	var partial []byte
+	buf := make([]byte, 4096)
+	for {
+		n, err := f.Read(buf)
+		partial = append(partial, buf[:n]...)
+		for {
+			i := bytes.IndexByte(partial, '\n')
+			if i < 0 {
+				break
+			}
+			check(partial[:i])
+			partial = partial[i+1:]
+		}
+		if err == io.EOF {
+			select {
+			case <-stop:
+				if n == 0 {
+					return
+				}
+			default:
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+}
+
+// While a file is appended to and its events processed by several
+// workers, a reader tailing the ndjson storage only ever sees whole
+// records, and the recorded size of the file never goes backwards.
+func TestNDJSONAppendWhileTailing(t *testing.T) {
+	dir := t.TempDir()
+	watched := filepath.Join(dir, "watched")
+	if err := os.Mkdir(watched, 0755); err != nil {
+		t.Fatal(err)
+	}
+	storage := filepath.Join(dir, "events.ndjson")
+	growing := filepath.Join(watched, "growing.log")
+	if err := os.WriteFile(growing, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(storage, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := newTestProcessor(t, Config{StorageLocation: storage, Format: formatNDJSON}, []string{watched})
+
+	tail, err := os.Open(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tail.Close()
+	stopTail := make(chan struct{})
+	tailed := make(chan int)
+	go func() {
+		lines := 0
+		tailLines(tail, stopTail, func(line []byte) {
+			var fileData FileData
+			if err := json.Unmarshal(line, &fileData); err != nil {
+				t.Errorf("tailed a partial record %q: %v", line, err)
+			}
+			lines++
+		})
+		tailed <- lines
+	}()
+
+	const processed, workers = 500, 4
+	events := make(chan fileEvent)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ev := range events {
+				p.processFile(ev)
+			}
+		}()
+	}
+	f, err := os.OpenFile(growing, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stopWriter := make(chan struct{})
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		for {
+			select {
+			case <-stopWriter:
+				return
+			default:
+			}
+			if _, err := f.Write([]byte("another line of log output\n")); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < processed; i++ {
+		events <- fileEvent{Path: growing, Event: eventWrite}
+	}
+	close(events)
+	wg.Wait()
+	close(stopWriter)
+	<-writerDone
+	p.rec.close()
+	close(stopTail)
+	if lines := <-tailed; lines != processed {
+		t.Errorf("tailed %d records, want %d", lines, processed)
+	}
+
+	records, err := readRecords(storage, formatNDJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Seq < records[j].Seq })
+	for i := 1; i < len(records); i++ {
+		if records[i].Size < records[i-1].Size {
+			t.Fatalf("record %d has size %d after %d", records[i].Seq, records[i].Size, records[i-1].Size)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// defaultStorageOpenFiles is how many partition files a templated
+// storage_location keeps open when storage_open_files is unset.
+const defaultStorageOpenFiles = 32
+
+// storagePathData is the value a templated storage_location is executed
+// against: every FileData field plus the fields key_template offers.
+// Aggregate records provide Path and WatchRoot only.
+type storagePathData struct {
+	FileData
+	RelPath string // path relative to the watch root, slash-separated
+	Name    string // base name
+	Ext     string // extension without the leading dot
+	Date    string // date the record was made, as YYYY-MM-DD
+}
+
+// isStorageTemplate reports whether storageLocation is a template rather
+// than a single file.
+func isStorageTemplate(storageLocation string) bool {
+	return strings.Contains(storageLocation, "{{")
+}
+
+// storageBaseDir returns the directory all files of storageLocation live
+// under: the directory part before the first template action.
+func storageBaseDir(storageLocation string) string {
+	if i := strings.Index(storageLocation, "{{"); i >= 0 {
+		storageLocation = storageLocation[:i] + "x"
+	}
+	return filepath.Dir(storageLocation)
+}
+
+// existingAncestor returns dir, or its closest parent that exists.
+func existingAncestor(dir string) string {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// parseStorageTemplate parses storageLocation and executes it once against
+// empty data so references to unknown fields are caught at startup.
+func parseStorageTemplate(storageLocation string) (*template.Template, error) {
+	t, err := template.New("storage").Option("missingkey=error").Parse(storageLocation)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Execute(&bytes.Buffer{}, storagePathData{}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// renderStoragePath returns the file record belongs in. .Date comes from
+// the record's own time, so a batch flushed after midnight still lands in
+// the files of the day its records were made. The result must stay inside
+// the base directory, so a field like a path cannot send records
+// elsewhere.
+func renderStoragePath(t *template.Template, base string, record interface{}) (string, error) {
+	var fileData FileData
+	switch r := record.(type) {
+	case FileData:
+		fileData = r
+	case DirAggregate:
+		fileData = FileData{Path: r.Path, WatchRoot: r.WatchRoot, Time: r.Time}
+	}
+	when := fileData.Time
+	if when.IsZero() {
+		when = time.Now()
+	}
+	rel, err := filepath.Rel(fileData.WatchRoot, fileData.Path)
+	if err != nil || fileData.WatchRoot == "" {
+		rel = fileData.Path
+	}
+	data := storagePathData{
+		FileData: fileData,
+		RelPath:  filepath.ToSlash(rel),
+		Name:     filepath.Base(fileData.Path),
+		Ext:      strings.TrimPrefix(filepath.Ext(fileData.Path), "."),
+		Date:     when.Format("2006-01-02"),
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	path := filepath.Clean(buf.String())
+	if inside, err := filepath.Rel(base, path); err != nil || inside == ".." || strings.HasPrefix(inside, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("storage path %s is outside %s", path, base)
+	}
+	return path, nil
+}
+
+// handleCache keeps up to max storage files open for appending, closing
+// the least recently used one to make room.
+type handleCache struct {
+	max     int
+	order   *list.List // of *os.File, most recently used first
+	entries map[string]*list.Element
+}
+
+func newHandleCache(max int) *handleCache {
+	if max <= 0 {
+		max = defaultStorageOpenFiles
+	}
+	return &handleCache{max: max, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// get returns path opened for appending, creating it and its directories
+// as needed.
+func (c *handleCache) get(path string) (*os.File, error) {
+	if el, ok := c.entries[path]; ok {
+		c.order.MoveToFront(el)
+		return el.Value.(*os.File), nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	for c.order.Len() >= c.max {
+		c.evict(c.order.Back())
+	}
+	c.entries[path] = c.order.PushFront(f)
+	return f, nil
+}
+
+// has reports whether path is open in the cache.
+func (c *handleCache) has(path string) bool {
+	_, ok := c.entries[path]
+	return ok
+}
+
+// drop closes path, for example after a failed write.
+func (c *handleCache) drop(path string) {
+	if el, ok := c.entries[path]; ok {
+		c.evict(el)
+	}
+}
+
+func (c *handleCache) evict(el *list.Element) {
+	f := c.order.Remove(el).(*os.File)
+	delete(c.entries, f.Name())
+	f.Close()
+}
+
+// closeAll closes every cached file.
+func (c *handleCache) closeAll() {
+	for c.order.Len() > 0 {
+		c.evict(c.order.Back())
+	}
+}
+
+// appendPartitioned writes each record to the file t resolves it to.
+// Records that cannot be placed are logged and skipped. The records of
+// files that could not be written are returned, with the first write
+// error, after the remaining files have been tried.
+func appendPartitioned(t *template.Template, base, format string, handles *handleCache, records []interface{}) ([]interface{}, error) {
+	var paths []string
+	groups := make(map[string][]interface{})
+	for _, record := range records {
+		path, err := renderStoragePath(t, base, record)
+		if err != nil {
+			log.Printf("Failed to resolve storage path: %v", err)
+			continue
+		}
+		if _, ok := groups[path]; !ok {
+			paths = append(paths, path)
+		}
+		groups[path] = append(groups[path], record)
+	}
+
+	var failed []interface{}
+	var firstErr error
+	for _, path := range paths {
+		if err := appendPartition(path, format, handles, groups[path]); err != nil {
+			failed = append(failed, groups[path]...)
+			err = fmt.Errorf("%s: %v", path, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return failed, firstErr
+}
+
+// appendPartition adds records to one partition file. The json format
+// rewrites the whole array, so only ndjson and csv use the cached handles.
+func appendPartition(path, format string, handles *handleCache, records []interface{}) error {
+	if format != formatNDJSON && format != formatCSV {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		return appendJSON(path, records)
+	}
+
+	// The header is checked when a partition is opened; rows appended
+	// through a cached handle always use csvHeader.
+	header := false
+	if format == formatCSV && !handles.has(path) {
+		var err error
+		if header, err = prepareCSV(path, time.Now()); err != nil {
+			return err
+		}
+	}
+	f, err := handles.get(path)
+	if err != nil {
+		return fmt.Errorf("failed to open storage file: %v", err)
+	}
+	var data []byte
+	if format == formatCSV {
+		info, statErr := f.Stat()
+		data, err = encodeCSV(records, header || statErr != nil || info.Size() == 0)
+	} else {
+		data, err = encodeNDJSON(records)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		handles.drop(path)
+		return fmt.Errorf("failed to write storage file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// A batch holding records from either side of midnight is split by each
+// record's own date, not the date it is flushed on.
+func TestAppendPartitionedUsesRecordTime(t *testing.T) {
+	base := t.TempDir()
+	tmpl, err := parseStorageTemplate(filepath.Join(base, "{{.Date}}", "{{.Ext}}.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := time.Date(2024, 5, 1, 23, 59, 59, 0, time.Local)
+	after := before.Add(2 * time.Second)
+	records := []interface{}{
+		FileData{Seq: 1, Path: "/w/a.log", Time: before},
+		FileData{Seq: 2, Path: "/w/b.log", Time: after},
+		FileData{Seq: 3, Path: "/w/c.log", Time: before},
+		DirAggregate{Type: recordTypeAggregate, Path: "/w/x.log", Time: after},
+	}
+	handles := newHandleCache(4)
+	if _, err := appendPartitioned(tmpl, base, formatNDJSON, handles, records); err != nil {
+		t.Fatal(err)
+	}
+	handles.closeAll()
+
+	// readRecords skips aggregates, so count lines as well
+	for _, tt := range []struct {
+		day   string
+		seqs  string
+		lines int
+	}{
+		{"2024-05-01", "[1 3]", 2},
+		{"2024-05-02", "[2]", 2},
+	} {
+		path := filepath.Join(base, tt.day, "log.ndjson")
+		got, err := readRecords(path, formatNDJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var seqs []uint64
+		for _, record := range got {
+			seqs = append(seqs, record.Seq)
+		}
+		if fmt.Sprint(seqs) != tt.seqs {
+			t.Errorf("%s holds seqs %v, want %s", tt.day, seqs, tt.seqs)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lines := bytes.Count(data, []byte("\n")); lines != tt.lines {
+			t.Errorf("%s holds %d records, want %d", tt.day, lines, tt.lines)
+		}
+	}
+}
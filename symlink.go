@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// linkTarget returns where the symlink at path points, as an absolute path
+// with every link along the way resolved. A broken link yields its raw
+// target as stored, which may be relative.
+func linkTarget(path string) string {
+	raw, err := os.Readlink(path)
+	if err != nil {
+		return ""
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return raw
+	}
+	if abs, err := filepath.Abs(resolved); err == nil {
+		return abs
+	}
+	return resolved
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// symlink creates link pointing at target, skipping the test where the
+// user may not create symlinks, as on Windows without the privilege.
+func symlink(t *testing.T, target, link string) {
+	t.Helper()
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("cannot create symlinks: %v", err)
+	}
+}
+
+func TestLinkTarget(t *testing.T) {
+	dir := t.TempDir()
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "file.txt")
+	if err := ioutil.WriteFile(file, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	symlink(t, file, filepath.Join(dir, "absolute"))
+	symlink(t, "file.txt", filepath.Join(dir, "relative"))
+	symlink(t, "relative", filepath.Join(dir, "chain"))
+	symlink(t, "missing.txt", filepath.Join(dir, "broken"))
+
+	tests := []struct {
+		link string
+		want string
+	}{
+		{"absolute", filepath.Join(resolved, "file.txt")},
+		{"relative", filepath.Join(resolved, "file.txt")},
+		{"chain", filepath.Join(resolved, "file.txt")},
+		{"broken", "missing.txt"},
+		{"file.txt", ""},
+	}
+	for _, tt := range tests {
+		if got := linkTarget(filepath.Join(dir, tt.link)); got != tt.want {
+			t.Errorf("linkTarget(%s) = %q, want %q", tt.link, got, tt.want)
+		}
+	}
+}
+
+// With follow_symlinks off, links are recorded as themselves with their
+// target, and a broken link is recorded rather than failing to stat.
+func TestProcessFileRecordsSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	watched := filepath.Join(dir, "watched")
+	if err := os.Mkdir(watched, 0755); err != nil {
+		t.Fatal(err)
+	}
+	resolved, err := filepath.EvalSymlinks(watched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(watched, "file.txt"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	symlink(t, "file.txt", filepath.Join(watched, "relative"))
+	symlink(t, "missing.txt", filepath.Join(watched, "broken"))
+
+	storage := filepath.Join(dir, "events.ndjson")
+	p := newTestProcessor(t, Config{StorageLocation: storage, Format: formatNDJSON}, []string{watched})
+	p.config.FollowSymlinks = false
+	for _, name := range []string{"relative", "broken"} {
+		p.processFile(fileEvent{Path: filepath.Join(watched, name), Event: eventCreate})
+	}
+	p.rec.close()
+
+	records, err := readRecords(storage, formatNDJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		filepath.Join(watched, "relative"): filepath.Join(resolved, "file.txt"),
+		filepath.Join(watched, "broken"):   "missing.txt",
+	}
+	if len(records) != len(want) {
+		t.Fatalf("recorded %d records, want %d: %+v", len(records), len(want), records)
+	}
+	for _, record := range records {
+		if target, ok := want[record.Path]; !ok || record.LinkTarget != target {
+			t.Errorf("%s recorded with link_target %q, want %q", record.Path, record.LinkTarget, target)
+		}
+		if record.Checksum != "" {
+			t.Errorf("%s has checksum %s; the link itself is not read", record.Path, record.Checksum)
+		}
+	}
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sinkProbeTimeout bounds each connection attempt probeSinks makes.
+const sinkProbeTimeout = 3 * time.Second
+
+// FieldError describes a problem with one configuration setting.
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ConfigError collects every problem found in a configuration, so all of
+// them can be reported at once. Callers can type-assert the error returned
+// by validateConfig and iterate over Errors.
+type ConfigError struct {
+	Errors []FieldError
+}
+
+func (e *ConfigError) Error() string {
+	lines := make([]string, len(e.Errors))
+	for i, fe := range e.Errors {
+		lines[i] = fe.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// addf records a problem with field.
+func (e *ConfigError) addf(field, format string, args ...interface{}) {
+	e.Errors = append(e.Errors, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// add records err against field, keeping the field of a FieldError.
+func (e *ConfigError) add(field string, err error) {
+	var fe FieldError
+	if errors.As(err, &fe) {
+		e.Errors = append(e.Errors, fe)
+		return
+	}
+	e.addf(field, "%v", err)
+}
+
+// validateConfig checks the loaded configuration without starting the
+// watcher. It returns a *ConfigError listing every problem found, so a
+// single run of -check surfaces all of them.
+func validateConfig(config Config) error {
+	problems := &ConfigError{}
+
+	if config.TargetDirectory != "" {
+		if info, err := os.Stat(config.TargetDirectory); err != nil {
+			problems.add("target_directory", err)
+		} else if !info.IsDir() {
+			problems.addf("target_directory", "%s is not a directory", config.TargetDirectory)
+		}
+	}
+	if _, err := watchRoots(config); err != nil {
+		problems.add("target_directories", err)
+	}
+
+	if config.MaxDepth < 0 {
+		problems.addf("max_depth", "must not be negative, got %d", config.MaxDepth)
+	}
+
+	switch config.WatchMode {
+	case "", watchModeNotify, watchModePoll:
+	default:
+		problems.addf("watch_mode", "unknown watch mode %q", config.WatchMode)
+	}
+	switch config.WatchStrategy {
+	case "", watchStrategyRecursive:
+	case watchStrategyHybrid:
+		if config.WatchMode == watchModePoll {
+			problems.addf("watch_strategy", "hybrid needs watch_mode notify")
+		}
+		if config.HybridPattern == "" {
+			problems.addf("hybrid_pattern", "is required with watch_strategy hybrid")
+		} else if _, err := filepath.Match(config.HybridPattern, ""); err != nil {
+			problems.addf("hybrid_pattern", "%v", err)
+		}
+	default:
+		problems.addf("watch_strategy", "unknown watch strategy %q", config.WatchStrategy)
+	}
+	if config.HybridSweepInterval < 0 {
+		problems.addf("hybrid_sweep_interval", "must not be negative, got %v", config.HybridSweepInterval)
+	}
+	if config.PollInterval < 0 {
+		problems.addf("poll_interval", "must not be negative, got %v", config.PollInterval)
+	}
+
+	if config.ConcurrencyLevel < 1 {
+		problems.addf("concurrency_level", "must be at least 1, got %d", config.ConcurrencyLevel)
+	}
+
+	if config.MaxOpenFiles < 0 {
+		problems.addf("max_open_files", "must not be negative, got %d", config.MaxOpenFiles)
+	}
+
+	if config.LockedFileRetries < 0 {
+		problems.addf("locked_file_retries", "must not be negative, got %d", config.LockedFileRetries)
+	}
+
+	if _, err := compilePatterns(config.ExcludeRegex); err != nil {
+		problems.add("exclude_regex", err)
+	}
+	if _, err := compileGlobs(config.IncludePatterns); err != nil {
+		problems.add("include_patterns", err)
+	}
+	if _, err := compileGlobs(config.ExcludePatterns); err != nil {
+		problems.add("exclude_patterns", err)
+	}
+
+	for _, name := range config.IncludeMagic {
+		if !knownMagic(name) {
+			problems.addf("include_magic", "unknown file type %q", name)
+		}
+	}
+
+	if strings.ContainsAny(config.CompletionMarkerSuffix, `/\`) {
+		problems.addf("completion_marker_suffix", "must not contain a path separator, got %q", config.CompletionMarkerSuffix)
+	}
+
+	if config.DedupeTTL < 0 {
+		problems.addf("dedupe_ttl", "must not be negative, got %v", config.DedupeTTL)
+	}
+
+	if config.MoveWindow < 0 {
+		problems.addf("move_window", "must not be negative, got %v", config.MoveWindow)
+	}
+
+	if config.SampleRate <= 0 || config.SampleRate > 1 {
+		problems.addf("sample_rate", "must be above 0 and at most 1, got %v", config.SampleRate)
+	}
+
+	if config.MaxRecordBytes < 0 {
+		problems.addf("max_record_bytes", "must not be negative, got %d", config.MaxRecordBytes)
+	}
+
+	if config.BatchSize < 0 {
+		problems.addf("batch_size", "must not be negative, got %d", config.BatchSize)
+	}
+	if config.FlushInterval < 0 {
+		problems.addf("flush_interval", "must not be negative, got %v", config.FlushInterval)
+	}
+
+	if config.StatsInterval < 0 {
+		problems.addf("stats_interval", "must not be negative, got %v", config.StatsInterval)
+	}
+
+	if config.DebounceInterval < 0 {
+		problems.addf("debounce_interval", "must not be negative, got %v", config.DebounceInterval)
+	}
+	if _, err := parseDebounceByExt(config.DebounceByExt); err != nil {
+		problems.add("debounce_by_ext", err)
+	}
+
+	if config.HashBufferSize < 0 {
+		problems.addf("hash_buffer_size", "must not be negative, got %d", config.HashBufferSize)
+	}
+
+	if config.RunDuration < 0 {
+		problems.addf("run_duration", "must not be negative, got %v", config.RunDuration)
+	}
+
+	if config.WarmupDelay < 0 {
+		problems.addf("warmup_delay", "must not be negative, got %v", config.WarmupDelay)
+	}
+
+	if config.SettleDelay < 0 {
+		problems.addf("settle_delay", "must not be negative, got %v", config.SettleDelay)
+	}
+
+	if _, err := compileFilter(config.Filter); err != nil {
+		problems.add("filter", err)
+	}
+
+	if _, err := parseKeyTemplate(config.KeyTemplate); err != nil {
+		problems.add("key_template", err)
+	}
+
+	if _, err := newCommandRunner(config); err != nil {
+		problems.add("exec_command", err)
+	}
+	if config.ExecTimeout < 0 {
+		problems.addf("exec_timeout", "must not be negative, got %v", config.ExecTimeout)
+	}
+	if config.ExecConcurrency < 0 {
+		problems.addf("exec_concurrency", "must not be negative, got %d", config.ExecConcurrency)
+	}
+
+	switch config.Format {
+	case "", formatJSON, formatNDJSON, formatCSV:
+	default:
+		problems.addf("format", "unknown storage format %q", config.Format)
+	}
+
+	if config.AggregateInterval < 0 {
+		problems.addf("aggregate_interval", "must not be negative, got %v", config.AggregateInterval)
+	} else if config.AggregateInterval > 0 && config.Format == formatCSV {
+		problems.addf("aggregate_interval", "aggregate records cannot be stored in the csv format")
+	}
+
+	for _, name := range config.RecordEvents {
+		if !knownEvent(name) {
+			problems.addf("record_events", "unknown event %q", name)
+		}
+	}
+
+	names := make(map[string]bool, len(config.Sinks))
+	for _, sink := range config.Sinks {
+		if err := validateSinkConfig(sink); err != nil {
+			problems.add("sinks", err)
+		}
+		if names[sink.Name] {
+			problems.addf("sinks", "duplicate name %q", sink.Name)
+		}
+		names[sink.Name] = true
+	}
+	for _, name := range config.DefaultSinks {
+		if !names[name] {
+			problems.addf("default_sinks", "unknown sink %q", name)
+		}
+	}
+	for _, route := range config.Routes {
+		if err := validateRoute(route, names); err != nil {
+			problems.add("routes", err)
+		}
+	}
+
+	if config.RetryQueue != "" {
+		if err := checkWritable(config.RetryQueue); err != nil {
+			problems.add("retry_queue", err)
+		}
+	}
+
+	if config.ControlSocket != "" {
+		if info, err := os.Stat(filepath.Dir(config.ControlSocket)); err != nil {
+			problems.add("control_socket", err)
+		} else if !info.IsDir() {
+			problems.addf("control_socket", "%s is not a directory", filepath.Dir(config.ControlSocket))
+		}
+	}
+
+	if config.LogMaxSizeMB < 0 {
+		problems.addf("log_max_size_mb", "must not be negative, got %d", config.LogMaxSizeMB)
+	}
+	if config.LogMaxBackups < 0 {
+		problems.addf("log_max_backups", "must not be negative, got %d", config.LogMaxBackups)
+	}
+	if config.LogFile != "" {
+		if err := checkWritable(config.LogFile); err != nil {
+			problems.add("log_file", err)
+		}
+	}
+
+	if config.MinFreeDiskBytes < 0 {
+		problems.addf("min_free_disk_bytes", "must not be negative, got %d", config.MinFreeDiskBytes)
+	}
+	if config.MaxHeldRecords < 0 {
+		problems.addf("max_held_records", "must not be negative, got %d", config.MaxHeldRecords)
+	}
+
+	if config.RemoteProvider != "" {
+		if !knownRemoteProvider(config.RemoteProvider) {
+			problems.addf("remote_provider", "unknown provider %q", config.RemoteProvider)
+		}
+		if config.RemoteEndpoint == "" {
+			problems.addf("remote_endpoint", "is required with remote_provider")
+		}
+		if config.RemoteKey == "" {
+			problems.addf("remote_key", "is required with remote_provider")
+		}
+	}
+	if config.RemoteRefresh < 0 {
+		problems.addf("remote_refresh", "must not be negative, got %v", config.RemoteRefresh)
+	}
+
+	if config.StorageOpenFiles < 0 {
+		problems.addf("storage_open_files", "must not be negative, got %d", config.StorageOpenFiles)
+	}
+	switch {
+	case config.StorageLocation == "":
+		problems.addf("storage_location", "is not set")
+	case isStorageTemplate(config.StorageLocation):
+		if _, err := parseStorageTemplate(config.StorageLocation); err != nil {
+			problems.add("storage_location", err)
+		}
+		// Partition directories are created on demand below the base
+		dir := existingAncestor(storageBaseDir(config.StorageLocation))
+		if err := checkWritable(filepath.Join(dir, ".check")); err != nil {
+			problems.add("storage_location", err)
+		}
+	default:
+		if err := checkWritable(config.StorageLocation); err != nil {
+			problems.add("storage_location", err)
+		}
+	}
+
+	if len(problems.Errors) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// printConfigError writes the problems in err to standard error, one per
+// line.
+func printConfigError(err error) {
+	fmt.Fprintln(os.Stderr, "Configuration problems:")
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) {
+		fmt.Fprintf(os.Stderr, "  %v\n", err)
+		return
+	}
+	for _, fe := range cfgErr.Errors {
+		fmt.Fprintf(os.Stderr, "  %s: %s\n", fe.Field, fe.Message)
+	}
+}
+
+// probeSinks checks that every webhook sink accepts connections, without
+// sending it anything: a request could be taken for a real record. It
+// returns a *ConfigError listing the unreachable sinks, or nil. -check runs
+// it after validateConfig.
+func probeSinks(sinks []SinkConfig) error {
+	problems := &ConfigError{}
+	for _, sink := range sinks {
+		if sink.Type != sinkWebhook {
+			continue
+		}
+		if err := dialURL(sink.URL, sinkProbeTimeout); err != nil {
+			problems.addf("sinks", "sink %q: %s is unreachable: %v", sink.Name, sink.URL, err)
+		}
+	}
+	if len(problems.Errors) == 0 {
+		return nil
+	}
+	return problems
+}
+
+// dialURL opens and closes a TCP connection to the host of rawURL, on the
+// scheme's default port when none is given.
+func dialURL(rawURL string, timeout time.Duration) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if u.Host == "" {
+		return fmt.Errorf("no host in url")
+	}
+	host := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+	conn, err := net.DialTimeout("tcp", host, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// checkWritable verifies that path can be written without modifying it. An
+// existing file is opened for writing; otherwise a probe file is created and
+// removed in the parent directory.
+func checkWritable(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	}
+
+	probe, err := ioutil.TempFile(filepath.Dir(path), ".check-")
+	if err != nil {
+		return err
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
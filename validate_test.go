@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProbeSinks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("probe sent a %s request", r.Method)
+	}))
+	defer server.Close()
+
+	// A port that was listening a moment ago refuses connections now
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := "http://" + l.Addr().String() + "/events"
+	l.Close()
+
+	sinks := []SinkConfig{
+		{Name: "console", Type: sinkStdout},
+		{Name: "up", Type: sinkWebhook, URL: server.URL + "/events"},
+		{Name: "down", Type: sinkWebhook, URL: closed},
+	}
+	err = probeSinks(sinks)
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("probeSinks = %v, want a *ConfigError", err)
+	}
+	if len(cfgErr.Errors) != 1 || cfgErr.Errors[0].Field != "sinks" {
+		t.Fatalf("problems = %v, want one for the down sink", cfgErr.Errors)
+	}
+
+	if err := probeSinks(sinks[:2]); err != nil {
+		t.Errorf("reachable sinks reported: %v", err)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// rebuildBackoff is the first delay between failed attempts to rebuild the
+// watcher; it doubles up to maxRebuildBackoff.
+const (
+	rebuildBackoff    = time.Second
+	maxRebuildBackoff = time.Minute
+)
+
+// fileWatcher is the part of an fsnotify watcher an eventSource uses, so
+// tests can feed it events and errors.
+type fileWatcher interface {
+	add(dir string) error
+	close() error
+	events() <-chan fsnotify.Event
+	errors() <-chan error
+}
+
+// fsWatcher adapts an fsnotify watcher to fileWatcher.
+type fsWatcher struct {
+	w *fsnotify.Watcher
+}
+
+func newFSWatcher() (fileWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	return fsWatcher{w}, nil
+}
+
+func (f fsWatcher) add(dir string) error          { return f.w.Add(dir) }
+func (f fsWatcher) close() error                  { return f.w.Close() }
+func (f fsWatcher) events() <-chan fsnotify.Event { return f.w.Events }
+func (f fsWatcher) errors() <-chan error          { return f.w.Errors }
+
+// eventSource delivers fsnotify events for a set of directories. When the
+// underlying watcher reports an error that means it has stopped delivering
+// events, a fresh watcher is created and every directory re-registered.
+type eventSource struct {
+	newWatcher func() (fileWatcher, error)
+
+	mu      sync.Mutex
+	dirs    map[string]bool
+	watcher fileWatcher
+	closed  bool
+	done    chan struct{}
+}
+
+func newEventSource(dirs []string) (*eventSource, error) {
+	return newEventSourceWith(dirs, newFSWatcher)
+}
+
+// newEventSourceWith watches dirs with watchers made by newWatcher. Every
+// directory must be watchable at this point.
+func newEventSourceWith(dirs []string, newWatcher func() (fileWatcher, error)) (*eventSource, error) {
+	watcher, _, err := registerAll(newWatcher, dirs, false)
+	if err != nil {
+		return nil, err
+	}
+	s := &eventSource{
+		newWatcher: newWatcher,
+		dirs:       make(map[string]bool, len(dirs)),
+		watcher:    watcher,
+		done:       make(chan struct{}),
+	}
+	for _, dir := range dirs {
+		s.dirs[dir] = true
+	}
+	return s, nil
+}
+
+// add registers dir with the watcher and remembers it for rebuilds.
+func (s *eventSource) add(dir string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dirs[dir] {
+		return nil
+	}
+	if err := s.watcher.add(dir); err != nil {
+		return err
+	}
+	s.dirs[dir] = true
+	return nil
+}
+
+// watching reports whether dir is among the watched directories.
+func (s *eventSource) watching(dir string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dirs[dir]
+}
+
+// forget drops dir from the set re-registered on rebuild. The watch itself
+// goes away when the directory is removed.
+func (s *eventSource) forget(dir string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.dirs, dir)
+}
+
+// registerAll creates a watcher registered on every directory in dirs.
+// With skipGone, directories that no longer exist are logged and returned
+// in gone instead of failing the whole watcher.
+func registerAll(newWatcher func() (fileWatcher, error), dirs []string, skipGone bool) (watcher fileWatcher, gone []string, err error) {
+	watcher, err = newWatcher()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, dir := range dirs {
+		err := watcher.add(dir)
+		if err == nil {
+			continue
+		}
+		if skipGone && isGone(err) {
+			log.Printf("No longer watching %s: %v", dir, err)
+			gone = append(gone, dir)
+			continue
+		}
+		watcher.close()
+		return nil, nil, err
+	}
+	return watcher, gone, nil
+}
+
+// isGone reports whether err from adding a watch means the directory has
+// been removed or replaced by a file.
+func isGone(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR)
+}
+
+// isFatalWatchError reports whether err means the watcher can no longer be
+// trusted to deliver events. A queue overflow loses events but leaves the
+// watches in place; a failing system call in the watcher's read loop does
+// not.
+func isFatalWatchError(err error) bool {
+	if errors.Is(err, fsnotify.ErrEventOverflow) {
+		return false
+	}
+	var errno syscall.Errno
+	return errors.As(err, &errno)
+}
+
+// run passes every event to handle until close is called.
+func (s *eventSource) run(handle func(fsnotify.Event)) {
+	for {
+		s.mu.Lock()
+		watcher := s.watcher
+		s.mu.Unlock()
+
+		select {
+		case <-s.done:
+			return
+		case event, ok := <-watcher.events():
+			if !ok {
+				if !s.rebuildUntilDone("event channel closed") {
+					return
+				}
+				continue
+			}
+			handle(event)
+		case err, ok := <-watcher.errors():
+			if !ok {
+				if !s.rebuildUntilDone("error channel closed") {
+					return
+				}
+				continue
+			}
+			if !isFatalWatchError(err) {
+				log.Println("error:", err)
+				continue
+			}
+			if !s.rebuildUntilDone(err.Error()) {
+				return
+			}
+		}
+	}
+}
+
+// rebuildUntilDone replaces the watcher, retrying with backoff until it
+// succeeds or the source is closed. It reports whether a new watcher is in
+// place.
+func (s *eventSource) rebuildUntilDone(reason string) bool {
+	select {
+	case <-s.done:
+		// The channels closed because close was called
+		return false
+	default:
+	}
+
+	log.Printf("Watcher failed (%s), rebuilding", reason)
+	backoff := rebuildBackoff
+	for {
+		err := s.rebuild()
+		if err == nil {
+			log.Printf("Watcher rebuilt")
+			return true
+		}
+		log.Printf("Failed to rebuild watcher: %v, retrying in %v", err, backoff)
+		select {
+		case <-s.done:
+			return false
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxRebuildBackoff {
+			backoff = maxRebuildBackoff
+		}
+	}
+}
+
+// rebuild creates a fresh watcher on all directories and closes the old one.
+// Directories removed since they were registered are dropped.
+func (s *eventSource) rebuild() error {
+	s.mu.Lock()
+	dirs := make([]string, 0, len(s.dirs))
+	for dir := range s.dirs {
+		dirs = append(dirs, dir)
+	}
+	s.mu.Unlock()
+
+	watcher, gone, err := registerAll(s.newWatcher, dirs, true)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		watcher.close()
+		return errors.New("event source closed")
+	}
+	for _, dir := range gone {
+		delete(s.dirs, dir)
+	}
+	old := s.watcher
+	s.watcher = watcher
+	old.close()
+	return nil
+}
+
+// close stops run and releases the watcher.
+func (s *eventSource) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.done)
+	s.watcher.close()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fakeWatcher records the directories added to it and delivers whatever
+// the test sends on its channels.
+type fakeWatcher struct {
+	eventsC chan fsnotify.Event
+	errorsC chan error
+	missing map[string]bool // adds that fail with ENOENT
+
+	mu    sync.Mutex
+	added []string
+}
+
+func (f *fakeWatcher) add(dir string) error {
+	if f.missing[dir] {
+		return syscall.ENOENT
+	}
+	f.mu.Lock()
+	f.added = append(f.added, dir)
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *fakeWatcher) close() error                  { return nil }
+func (f *fakeWatcher) events() <-chan fsnotify.Event { return f.eventsC }
+func (f *fakeWatcher) errors() <-chan error          { return f.errorsC }
+
+func (f *fakeWatcher) dirs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	dirs := append([]string(nil), f.added...)
+	sort.Strings(dirs)
+	return dirs
+}
+
+// fakeWatchers hands out a new fakeWatcher per call, passing each one to
+// made.
+func fakeWatchers(missing map[string]bool, made chan<- *fakeWatcher) func() (fileWatcher, error) {
+	return func() (fileWatcher, error) {
+		w := &fakeWatcher{
+			eventsC: make(chan fsnotify.Event, 1),
+			errorsC: make(chan error, 1),
+			missing: missing,
+		}
+		made <- w
+		return w, nil
+	}
+}
+
+func nextWatcher(t *testing.T, made <-chan *fakeWatcher) *fakeWatcher {
+	t.Helper()
+	select {
+	case w := <-made:
+		return w
+	case <-time.After(5 * time.Second):
+		t.Fatal("watcher was not rebuilt")
+		return nil
+	}
+}
+
+func TestEventSourceRebuildReregisters(t *testing.T) {
+	missing := map[string]bool{}
+	made := make(chan *fakeWatcher, 2)
+	s, err := newEventSourceWith([]string{"/a", "/b"}, fakeWatchers(missing, made))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := nextWatcher(t, made)
+	if err := s.add("/a/c"); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []string
+	var mu sync.Mutex
+	go s.run(func(ev fsnotify.Event) {
+		mu.Lock()
+		events = append(events, ev.Name)
+		mu.Unlock()
+	})
+	defer s.close()
+
+	// /b is deleted before the rebuild; it must not stop the others
+	missing["/b"] = true
+	first.errorsC <- syscall.EBADF
+	second := nextWatcher(t, made)
+	if got, want := second.dirs(), []string{"/a", "/a/c"}; !equalStrings(got, want) {
+		t.Errorf("rebuilt watcher has %v, want %v", got, want)
+	}
+
+	second.eventsC <- fsnotify.Event{Name: "/a/file", Op: fsnotify.Create}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		n := len(events)
+		mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no event delivered by the rebuilt watcher")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dirs["/b"] {
+		t.Error("removed directory /b is still re-registered on rebuild")
+	}
+}
+
+func TestEventSourceIgnoresOverflow(t *testing.T) {
+	made := make(chan *fakeWatcher, 2)
+	s, err := newEventSourceWith([]string{"/a"}, fakeWatchers(nil, made))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := nextWatcher(t, made)
+	go s.run(func(fsnotify.Event) {})
+	defer s.close()
+
+	w.errorsC <- fsnotify.ErrEventOverflow
+	select {
+	case <-made:
+		t.Error("an overflow rebuilt the watcher")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}